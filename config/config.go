@@ -3,6 +3,7 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
@@ -13,38 +14,189 @@ type Addon struct {
 	Manifest string
 }
 
+// HTTPConfig configures outbound HTTP calls, see httpx.NewClient.
+type HTTPConfig struct {
+	UserAgent string
+	// Proxy is an http://, https:// or socks5:// URL. When empty the
+	// HTTP_PROXY and HTTPS_PROXY environment variables are used.
+	Proxy       string
+	TimeoutSecs int
+	// HostTimeoutSecs overrides TimeoutSecs for specific hosts.
+	HostTimeoutSecs map[string]int
+	// Retries is how many times a request failing with 429 or 5xx is retried.
+	Retries int
+}
+
+// RateLimits are in KiB/s, zero is unlimited.
+type RateLimits struct {
+	DownloadKiBps int
+	UploadKiBps   int
+}
+
+// BandwidthSchedule replaces the bandwidth limits during a time of day.
+type BandwidthSchedule struct {
+	// From and To are local times like "23:30". Schedules ending before they
+	// start go past midnight.
+	From   string
+	To     string
+	Limits RateLimits
+}
+
+type BandwidthConfig struct {
+	Limits RateLimits
+	// Background limits apply on top of the others while nothing is being
+	// streamed. Background downloads are paused while something else is
+	// streamed, so they never go past these limits.
+	Background RateLimits
+	// Schedules override Limits, the first one matching the time of day
+	// wins.
+	Schedules []BandwidthSchedule
+}
+
+// SeedingPolicy limits how long torrents are seeded once nothing is being
+// downloaded from them.
+type SeedingPolicy struct {
+	// Ratio stops seeding once the bytes uploaded reach Ratio times the
+	// bytes downloaded. Zero is unlimited.
+	Ratio float64
+	// Hours stops seeding after this long. Zero is unlimited.
+	Hours float64
+	// Streamed allows seeding torrents that were only streamed and never
+	// downloaded.
+	Streamed bool
+}
+
+// NetworkConfig configures how the torrent client reaches peers.
+type NetworkConfig struct {
+	// ListenPort is where peers connect to, zero picks a random port.
+	ListenPort int
+	// ListenHost is the IP or the name of the network interface, like a VPN
+	// tun device, to listen on. Empty listens on every interface.
+	ListenHost string
+	// NoPortForwarding disables asking the router to forward ListenPort
+	// through UPnP.
+	NoPortForwarding bool
+	DisableIPv6      bool
+	// Encryption is "prefer", "require" or "disable" for the header
+	// obfuscation of peer connections.
+	Encryption string
+}
+
+type TorrentConfig struct {
+	// MetadataTimeoutSecs is how long to wait for a torrent's metadata
+	// before giving up on a request.
+	MetadataTimeoutSecs int
+	// DefaultTrackers are announced to for every torrent, on top of the
+	// trackers listed by addons.
+	DefaultTrackers []string
+	// ReadaheadSecs is how many seconds of video are fetched ahead of the
+	// playhead while streaming.
+	ReadaheadSecs int
+	// FailoverTimeoutSecs is how long a torrent being played can stall
+	// before switching to another torrent of the same title. Zero disables
+	// failover.
+	FailoverTimeoutSecs int
+	// HealthProbeStreams is how many torrents at the top of each stream
+	// listing get their swarm probed in the background. Zero disables
+	// probing.
+	HealthProbeStreams int
+	// HealthProbeSecs is how long each probe looks for peers.
+	HealthProbeSecs int
+	// HealthMaxAgeMins is how long probe results are trusted.
+	HealthMaxAgeMins int
+	Bandwidth        BandwidthConfig
+	// Seeding applies to every torrent without a policy of its own.
+	Seeding SeedingPolicy
+	Network NetworkConfig
+	// BlocklistPath is an IP blocklist in the eMule DAT or P2P format,
+	// optionally gzipped, whose peers are never connected to.
+	BlocklistPath string
+	// BlocklistReloadMins is how often the blocklist is checked for changes.
+	BlocklistReloadMins int
+}
+
 type Config struct {
+	// Addr is the host:port the web interface is served at.
+	Addr string
+	// NoBrowser skips opening the web interface on startup, for headless
+	// setups.
+	NoBrowser bool
+	// LogLevel is debug, info, warn or error.
+	LogLevel string
+	// DataDir holds the database.
+	DataDir     string
 	PlayerCmd   string
 	DownloadDir string
 	SubLangs    []string
 	Addons      []Addon
+	LibraryDirs []string
+	HTTP        HTTPConfig
+	Torrent     TorrentConfig
 }
 
 func DefaultConfig() Config {
 	home, _ := os.UserHomeDir()
+	configDir, _ := os.UserConfigDir()
 	return Config{
+		Addr:        "localhost:2025",
+		LogLevel:    "debug",
+		DataDir:     configDir,
 		PlayerCmd:   "mpv {{.URL}} {{range .Subs}} --sub-file={{.URL}} {{end}}",
 		DownloadDir: filepath.Join(home, "Downloads", "anyflix"),
 		SubLangs:    []string{"pob"},
 		Addons:      []Addon{},
+		LibraryDirs: []string{},
+		HTTP: HTTPConfig{
+			UserAgent:       "Mozilla/5.0 (X11; Linux x86_64; rv:133.0) Gecko/20100101 Firefox/133.0",
+			TimeoutSecs:     15,
+			HostTimeoutSecs: map[string]int{},
+			Retries:         2,
+		},
+		Torrent: TorrentConfig{
+			MetadataTimeoutSecs: 60,
+			ReadaheadSecs:       30,
+			FailoverTimeoutSecs: 45,
+			HealthProbeSecs:     15,
+			HealthMaxAgeMins:    60,
+			Seeding: SeedingPolicy{
+				Streamed: true,
+			},
+			Network: NetworkConfig{
+				ListenPort: 42069,
+				Encryption: "prefer",
+			},
+			BlocklistReloadMins: 60,
+			DefaultTrackers: []string{
+				"udp://tracker.opentrackr.org:1337/announce",
+				"udp://open.demonii.com:1337/announce",
+				"udp://open.stealth.si:80/announce",
+				"udp://tracker.torrent.eu.org:451/announce",
+				"udp://exodus.desync.com:6969/announce",
+				"udp://tracker.openbittorrent.com:6969/announce",
+			},
+		},
 	}
 }
 
-func Load() (Config, error) {
-	var cfg Config
-
+// DefaultPath is where the config is kept unless another path is given.
+func DefaultPath() (string, error) {
 	path, err := os.UserConfigDir()
 	if err != nil {
-		return cfg, err
+		return "", err
 	}
+	return filepath.Join(path, "anyflix.json"), nil
+}
 
-	path = filepath.Join(path, "anyflix.json")
+// Load reads the config at path, creating it with the defaults when it
+// doesn't exist.
+func Load(path string) (Config, error) {
+	// fields missing from older config files keep their defaults
+	cfg := DefaultConfig()
 
 	b, err := os.ReadFile(path)
 	if errors.Is(err, os.ErrNotExist) {
-		cfg = DefaultConfig()
-		err = Save(cfg)
-		slog.Debug("initialized with default config")
+		err = Save(path, cfg)
+		slog.Debug("initialized with default config", "path", path)
 		return cfg, err
 	}
 	if err != nil {
@@ -53,21 +205,19 @@ func Load() (Config, error) {
 
 	err = json.Unmarshal(b, &cfg)
 	if err != nil {
-		return cfg, err
+		return cfg, fmt.Errorf("config %s: %w", path, err)
 	}
 
 	slog.Debug("loaded config", "path", path)
 	return cfg, nil
 }
 
-func Save(cfg Config) error {
-	path, err := os.UserConfigDir()
+func Save(path string, cfg Config) error {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
 	if err != nil {
 		return err
 	}
 
-	path = filepath.Join(path, "anyflix.json")
-
 	b, err := json.MarshalIndent(cfg, "", "  ")
 	if err != nil {
 		return err
@@ -3,10 +3,15 @@ package db
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/igorcafe/anyflix/config"
+	"github.com/igorcafe/anyflix/errorsx"
 	"github.com/igorcafe/anyflix/meta"
 )
 
@@ -30,16 +35,101 @@ CREATE TABLE recent (
 	id INTEGER PRIMARY KEY,
 	data TEXT NOT NULL,
 	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+)`),
+	// 2
+	migrationString(`
+CREATE TABLE library (
+	id INTEGER PRIMARY KEY,
+	path TEXT NOT NULL UNIQUE,
+	imdb_id TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	season INTEGER NOT NULL DEFAULT 0,
+	episode INTEGER NOT NULL DEFAULT 0,
+	title TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	mod_time INTEGER NOT NULL
+)`),
+	// 3
+	migrationString(`
+ALTER TABLE library ADD COLUMN info_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE library ADD COLUMN file_idx INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX library_torrent ON library (info_hash, file_idx);
+`),
+	// 4
+	migrationString(`
+CREATE TABLE meta_cache (
+	kind TEXT NOT NULL,
+	id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	data TEXT NOT NULL,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (kind, id)
+)`),
+	// 5
+	migrationString(`
+CREATE TABLE manual_stream (
+	id INTEGER PRIMARY KEY,
+	imdb_id TEXT NOT NULL,
+	season INTEGER NOT NULL DEFAULT 0,
+	episode INTEGER NOT NULL DEFAULT 0,
+	info_hash TEXT NOT NULL,
+	file_idx INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	trackers TEXT NOT NULL DEFAULT '[]',
+	UNIQUE (imdb_id, season, episode, info_hash, file_idx)
+)`),
+	// 6
+	migrationString(`
+CREATE TABLE dead_torrent (
+	info_hash TEXT PRIMARY KEY,
+	reason TEXT NOT NULL,
+	timestamp INTEGER NOT NULL
+)`),
+	// 7
+	migrationString(`
+CREATE TABLE swarm_health (
+	info_hash TEXT PRIMARY KEY,
+	peers INTEGER NOT NULL,
+	connected INTEGER NOT NULL,
+	seeders INTEGER NOT NULL,
+	score INTEGER NOT NULL,
+	timestamp INTEGER NOT NULL
+)`),
+	// 8
+	migrationString(`
+CREATE TABLE seeding_policy (
+	info_hash TEXT PRIMARY KEY,
+	ratio REAL NOT NULL,
+	hours REAL NOT NULL,
+	streamed INTEGER NOT NULL
+)`),
+	// 9
+	migrationString(`
+CREATE TABLE pending_download (
+	info_hash TEXT NOT NULL,
+	file_idx INTEGER NOT NULL,
+	PRIMARY KEY (info_hash, file_idx)
+)`),
+	// 10
+	migrationString(`
+CREATE TABLE seeding_state (
+	info_hash TEXT PRIMARY KEY,
+	uploaded INTEGER NOT NULL,
+	seeded_secs REAL NOT NULL,
+	requested INTEGER NOT NULL
 )`),
 }
 
-func Init() error {
-	path, err := os.UserConfigDir()
+// Init opens the database at path, creating it when needed, and migrates it
+// to the latest version.
+func Init(path string) error {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
 	if err != nil {
 		return err
 	}
 
-	db, err = sql.Open("sqlite", filepath.Join(path, "anyflix.db"))
+	db, err = sql.Open("sqlite", path)
 	if err != nil {
 		return err
 	}
@@ -138,3 +228,506 @@ func DeleteRecent(id string) error {
 	_, err := db.Exec(`DELETE FROM recent WHERE json_extract(data, '$.id') = ?`, id)
 	return err
 }
+
+// LibraryEntry is a local media file. IMDBID is empty when the file couldn't
+// be matched to any title.
+type LibraryEntry struct {
+	ID      int64  `json:"id"`
+	Path    string `json:"path"`
+	IMDBID  string `json:"imdbId"`
+	Kind    string `json:"kind"`
+	Season  int    `json:"season"`
+	Episode int    `json:"episode"`
+	Title   string `json:"title"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+
+	// InfoHash and FileIdx are set when the file was downloaded from a torrent.
+	InfoHash string `json:"infoHash,omitempty"`
+	FileIdx  int    `json:"fileIdx"`
+}
+
+const libraryColumns = `id, path, imdb_id, kind, season, episode, title, size, mod_time, info_hash, file_idx`
+
+func scanLibraryEntry(row interface{ Scan(...any) error }) (LibraryEntry, error) {
+	e := LibraryEntry{}
+	err := row.Scan(&e.ID, &e.Path, &e.IMDBID, &e.Kind, &e.Season, &e.Episode, &e.Title, &e.Size, &e.ModTime, &e.InfoHash, &e.FileIdx)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = errorsx.NotFound
+	}
+	return e, err
+}
+
+func queryLibrary(query string, args ...any) ([]LibraryEntry, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []LibraryEntry{}
+
+	for rows.Next() {
+		e, err := scanLibraryEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// SaveLibraryEntry inserts or updates the entry with the same path.
+func SaveLibraryEntry(e LibraryEntry) (int64, error) {
+	var id int64
+	err := db.QueryRow(`
+INSERT INTO library (path, imdb_id, kind, season, episode, title, size, mod_time)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (path) DO UPDATE SET
+	imdb_id = excluded.imdb_id,
+	kind = excluded.kind,
+	season = excluded.season,
+	episode = excluded.episode,
+	title = excluded.title,
+	size = excluded.size,
+	mod_time = excluded.mod_time
+RETURNING id`,
+		e.Path, e.IMDBID, e.Kind, e.Season, e.Episode, e.Title, e.Size, e.ModTime,
+	).Scan(&id)
+	return id, err
+}
+
+func ListLibrary() ([]LibraryEntry, error) {
+	return queryLibrary(`SELECT ` + libraryColumns + ` FROM library ORDER BY title, season, episode`)
+}
+
+func GetLibraryEntry(id int64) (LibraryEntry, error) {
+	return scanLibraryEntry(db.QueryRow(`SELECT `+libraryColumns+` FROM library WHERE id = ?`, id))
+}
+
+func FindLibraryEntry(path string) (LibraryEntry, error) {
+	return scanLibraryEntry(db.QueryRow(`SELECT `+libraryColumns+` FROM library WHERE path = ?`, path))
+}
+
+// FindLibraryVideo lists the files of a movie (season and episode 0) or of a
+// series episode.
+func FindLibraryVideo(imdbID string, season, episode int) ([]LibraryEntry, error) {
+	return queryLibrary(`SELECT `+libraryColumns+` FROM library
+WHERE imdb_id = ? AND season = ? AND episode = ?
+ORDER BY size DESC`, imdbID, season, episode)
+}
+
+// LinkLibraryTorrent records that the file at path was downloaded from the
+// given torrent file.
+func LinkLibraryTorrent(path, infoHash string, fileIdx int) error {
+	_, err := db.Exec(`UPDATE library SET info_hash = ?, file_idx = ? WHERE path = ?`,
+		strings.ToLower(infoHash), fileIdx, path)
+	return err
+}
+
+func FindLibraryTorrent(infoHash string, fileIdx int) (LibraryEntry, error) {
+	return scanLibraryEntry(db.QueryRow(`SELECT `+libraryColumns+` FROM library
+WHERE info_hash = ? AND file_idx = ?`, strings.ToLower(infoHash), fileIdx))
+}
+
+func DeleteLibraryEntry(path string) (int64, error) {
+	res, err := db.Exec(`DELETE FROM library WHERE path = ?`, path)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteLibraryTree removes every entry under dir, returning how many there
+// were.
+func DeleteLibraryTree(dir string) (int64, error) {
+	res, err := db.Exec(`DELETE FROM library WHERE substr(path, 1, length(?) + 1) = ? || '/'`, dir, dir)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// MoveLibraryEntries renames the entry at from, or every entry under it when
+// from is a directory, to live under to instead.
+func MoveLibraryEntries(from, to string) error {
+	_, err := db.Exec(`
+UPDATE library SET path = ? || substr(path, length(?) + 1)
+WHERE path = ? OR substr(path, 1, length(?) + 1) = ? || '/'`,
+		to, from, from, from, from)
+	return err
+}
+
+// ManualStream is a torrent added by hand and associated with a movie
+// (season and episode 0) or a series episode.
+type ManualStream struct {
+	ID       int64    `json:"id"`
+	IMDBID   string   `json:"imdbId"`
+	Season   int      `json:"season"`
+	Episode  int      `json:"episode"`
+	InfoHash string   `json:"infoHash"`
+	FileIdx  int      `json:"fileIdx"`
+	Name     string   `json:"name"`
+	Size     int64    `json:"size"`
+	Trackers []string `json:"trackers"`
+}
+
+func SaveManualStream(m ManualStream) (int64, error) {
+	trackers, err := json.Marshal(m.Trackers)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = db.QueryRow(`
+INSERT INTO manual_stream (imdb_id, season, episode, info_hash, file_idx, name, size, trackers)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (imdb_id, season, episode, info_hash, file_idx) DO UPDATE SET
+	name = excluded.name,
+	size = excluded.size,
+	trackers = excluded.trackers
+RETURNING id`,
+		m.IMDBID, m.Season, m.Episode, strings.ToLower(m.InfoHash), m.FileIdx, m.Name, m.Size, trackers,
+	).Scan(&id)
+	return id, err
+}
+
+func FindManualStreams(imdbID string, season, episode int) ([]ManualStream, error) {
+	rows, err := db.Query(`
+SELECT id, imdb_id, season, episode, info_hash, file_idx, name, size, trackers
+FROM manual_stream
+WHERE imdb_id = ? AND season = ? AND episode = ?
+ORDER BY id DESC`, imdbID, season, episode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	streams := []ManualStream{}
+
+	for rows.Next() {
+		var m ManualStream
+		var trackers []byte
+
+		err := rows.Scan(&m.ID, &m.IMDBID, &m.Season, &m.Episode, &m.InfoHash, &m.FileIdx, &m.Name, &m.Size, &trackers)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(trackers, &m.Trackers)
+		if err != nil {
+			return nil, err
+		}
+
+		streams = append(streams, m)
+	}
+
+	return streams, rows.Err()
+}
+
+// MarkTorrentDead records that a torrent couldn't be played, so it's skipped
+// when looking for another one.
+func MarkTorrentDead(infoHash, reason string) error {
+	_, err := db.Exec(`
+INSERT INTO dead_torrent (info_hash, reason, timestamp)
+VALUES (?, ?, ?)
+ON CONFLICT (info_hash) DO UPDATE SET
+	reason = excluded.reason,
+	timestamp = excluded.timestamp`,
+		strings.ToLower(infoHash), reason, time.Now().Unix(),
+	)
+	return err
+}
+
+// IsTorrentDead reports whether a torrent was marked dead after since.
+func IsTorrentDead(infoHash string, since time.Time) (bool, error) {
+	var dead bool
+	err := db.QueryRow(`
+SELECT EXISTS (
+	SELECT 1 FROM dead_torrent WHERE info_hash = ? AND timestamp > ?
+)`, strings.ToLower(infoHash), since.Unix()).Scan(&dead)
+	return dead, err
+}
+
+// SwarmHealth is the result of probing the swarm of a torrent.
+type SwarmHealth struct {
+	InfoHash  string `json:"infoHash"`
+	Peers     int    `json:"peers"`
+	Connected int    `json:"connected"`
+	Seeders   int    `json:"seeders"`
+	// Score goes from 0, for swarms without peers, to 100.
+	Score     int   `json:"score"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+func SaveSwarmHealth(h SwarmHealth) error {
+	_, err := db.Exec(`
+INSERT INTO swarm_health (info_hash, peers, connected, seeders, score, timestamp)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (info_hash) DO UPDATE SET
+	peers = excluded.peers,
+	connected = excluded.connected,
+	seeders = excluded.seeders,
+	score = excluded.score,
+	timestamp = excluded.timestamp`,
+		strings.ToLower(h.InfoHash), h.Peers, h.Connected, h.Seeders, h.Score, h.Timestamp,
+	)
+	return err
+}
+
+func GetSwarmHealth(infoHash string) (SwarmHealth, error) {
+	var h SwarmHealth
+	err := db.QueryRow(`
+SELECT info_hash, peers, connected, seeders, score, timestamp
+FROM swarm_health
+WHERE info_hash = ?`, strings.ToLower(infoHash),
+	).Scan(&h.InfoHash, &h.Peers, &h.Connected, &h.Seeders, &h.Score, &h.Timestamp)
+	if errors.Is(err, sql.ErrNoRows) {
+		return h, fmt.Errorf("swarm health of %s: %w", infoHash, errorsx.NotFound)
+	}
+	return h, err
+}
+
+// SaveSeedingPolicy sets the seeding policy of a single torrent, overriding
+// the global one.
+func SaveSeedingPolicy(infoHash string, p config.SeedingPolicy) error {
+	_, err := db.Exec(`
+INSERT INTO seeding_policy (info_hash, ratio, hours, streamed)
+VALUES (?, ?, ?, ?)
+ON CONFLICT (info_hash) DO UPDATE SET
+	ratio = excluded.ratio,
+	hours = excluded.hours,
+	streamed = excluded.streamed`,
+		strings.ToLower(infoHash), p.Ratio, p.Hours, p.Streamed,
+	)
+	return err
+}
+
+// ListSeedingPolicies returns the policies of the torrents that have one,
+// by info hash.
+func ListSeedingPolicies() (map[string]config.SeedingPolicy, error) {
+	rows, err := db.Query(`SELECT info_hash, ratio, hours, streamed FROM seeding_policy`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := map[string]config.SeedingPolicy{}
+	for rows.Next() {
+		var infoHash string
+		var p config.SeedingPolicy
+		err = rows.Scan(&infoHash, &p.Ratio, &p.Hours, &p.Streamed)
+		if err != nil {
+			return nil, err
+		}
+		policies[infoHash] = p
+	}
+
+	return policies, rows.Err()
+}
+
+// DeleteSeedingPolicy makes a torrent follow the global seeding policy again.
+func DeleteSeedingPolicy(infoHash string) error {
+	_, err := db.Exec(`DELETE FROM seeding_policy WHERE info_hash = ?`, strings.ToLower(infoHash))
+	return err
+}
+
+// PendingDownload is a download that was interrupted by a shutdown.
+type PendingDownload struct {
+	InfoHash string
+	FileIdx  int
+}
+
+// SavePendingDownloads records downloads to resume on the next start, until
+// DeletePendingDownload is called for them.
+func SavePendingDownloads(downloads []PendingDownload) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, d := range downloads {
+		_, err = tx.Exec(`
+INSERT INTO pending_download (info_hash, file_idx)
+VALUES (?, ?)
+ON CONFLICT DO NOTHING`, strings.ToLower(d.InfoHash), d.FileIdx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteTorrentDownloads forgets the pending downloads of every file of a
+// torrent.
+func DeleteTorrentDownloads(infoHash string) error {
+	_, err := db.Exec(`DELETE FROM pending_download WHERE info_hash = ?`, strings.ToLower(infoHash))
+	return err
+}
+
+func DeletePendingDownload(infoHash string, fileIdx int) error {
+	_, err := db.Exec(`
+DELETE FROM pending_download WHERE info_hash = ? AND file_idx = ?`,
+		strings.ToLower(infoHash), fileIdx,
+	)
+	return err
+}
+
+func ListPendingDownloads() ([]PendingDownload, error) {
+	rows, err := db.Query(`SELECT info_hash, file_idx FROM pending_download`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	downloads := []PendingDownload{}
+	for rows.Next() {
+		var d PendingDownload
+		err = rows.Scan(&d.InfoHash, &d.FileIdx)
+		if err != nil {
+			return nil, err
+		}
+		downloads = append(downloads, d)
+	}
+
+	return downloads, rows.Err()
+}
+
+// SeedingState is the seeding progress of a torrent, saved on shutdown so
+// the limits of its policy don't reset.
+type SeedingState struct {
+	InfoHash  string
+	Uploaded  int64
+	Seeded    time.Duration
+	Requested bool
+}
+
+func SaveSeedingStates(states []SeedingState) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, s := range states {
+		_, err = tx.Exec(`
+INSERT INTO seeding_state (info_hash, uploaded, seeded_secs, requested)
+VALUES (?, ?, ?, ?)
+ON CONFLICT (info_hash) DO UPDATE SET
+	uploaded = excluded.uploaded,
+	seeded_secs = excluded.seeded_secs,
+	requested = excluded.requested`,
+			strings.ToLower(s.InfoHash), s.Uploaded, s.Seeded.Seconds(), s.Requested,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func ListSeedingStates() ([]SeedingState, error) {
+	rows, err := db.Query(`SELECT info_hash, uploaded, seeded_secs, requested FROM seeding_state`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := []SeedingState{}
+	for rows.Next() {
+		var s SeedingState
+		var seededSecs float64
+		err = rows.Scan(&s.InfoHash, &s.Uploaded, &seededSecs, &s.Requested)
+		if err != nil {
+			return nil, err
+		}
+		s.Seeded = time.Duration(seededSecs * float64(time.Second))
+		states = append(states, s)
+	}
+
+	return states, rows.Err()
+}
+
+// DeleteSeedingState forgets the seeding progress of a torrent whose data
+// was deleted.
+func DeleteSeedingState(infoHash string) error {
+	_, err := db.Exec(`DELETE FROM seeding_state WHERE info_hash = ?`, strings.ToLower(infoHash))
+	return err
+}
+
+// Close closes the database, once nothing else uses it.
+func Close() error {
+	return db.Close()
+}
+
+// MetaCache stores metadata fetched from the meta API, so titles can still be
+// browsed while offline. It implements meta.Cache.
+type MetaCache struct{}
+
+func (MetaCache) GetMeta(kind, id string) (meta.Meta, error) {
+	var data []byte
+	err := db.QueryRow(`SELECT data FROM meta_cache WHERE kind = ? AND id = ?`, kind, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return meta.Meta{}, errorsx.NotFound
+	}
+	if err != nil {
+		return meta.Meta{}, err
+	}
+
+	m := meta.Meta{}
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+func (MetaCache) SaveMeta(kind string, m meta.Meta) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+INSERT INTO meta_cache (kind, id, name, data) VALUES (?, ?, ?, ?)
+ON CONFLICT (kind, id) DO UPDATE SET
+	name = excluded.name,
+	data = excluded.data,
+	timestamp = CURRENT_TIMESTAMP`,
+		kind, m.ID, m.Name, data)
+	return err
+}
+
+func (MetaCache) SearchMeta(kind, query string) ([]meta.Meta, error) {
+	// the query is taken literally, so its wildcards are escaped
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(query)
+
+	rows, err := db.Query(`SELECT data FROM meta_cache
+WHERE kind = ? AND name LIKE '%' || ? || '%' ESCAPE '\'
+ORDER BY timestamp DESC`, kind, escaped)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metas := []meta.Meta{}
+
+	for rows.Next() {
+		var data []byte
+		err := rows.Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+
+		m := meta.Meta{}
+		err = json.Unmarshal(data, &m)
+		if err != nil {
+			return nil, err
+		}
+
+		metas = append(metas, m)
+	}
+
+	return metas, rows.Err()
+}
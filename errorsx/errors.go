@@ -3,3 +3,12 @@ package errorsx
 import "errors"
 
 var NotFound = errors.New("not found")
+
+// Offline is returned by outbound HTTP calls while there is no network access.
+var Offline = errors.New("offline")
+
+// Timeout is returned when something didn't happen in the expected time.
+var Timeout = errors.New("timeout")
+
+// Invalid is returned for malformed input.
+var Invalid = errors.New("invalid")
@@ -0,0 +1,163 @@
+// Package events is an in-process publish/subscribe bus used to notify the
+// HTTP layer about things happening in the background.
+package events
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// subscriberBuffer is how many events a subscriber can fall behind before
+	// new events are dropped for it.
+	subscriberBuffer = 64
+
+	// maxDropped is how many events in a row can be dropped for a subscriber
+	// before it is closed, so clients too slow to ever catch up reconnect
+	// instead of missing events forever.
+	maxDropped = 256
+)
+
+// TopicDropped is sent to a subscriber once it catches up after events were
+// dropped for it, with the number of dropped events as data.
+const TopicDropped = "events.dropped"
+
+type Event struct {
+	Topic string    `json:"topic"`
+	Time  time.Time `json:"time"`
+	Data  any       `json:"data"`
+}
+
+type Bus struct {
+	mu     sync.Mutex
+	subs   map[*subscriber]struct{}
+	closed bool
+}
+
+type subscriber struct {
+	ch      chan Event
+	topics  []string
+	dropped int
+	closed  bool
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subs: map[*subscriber]struct{}{},
+	}
+}
+
+// Subscribe returns a channel receiving the events published from now on,
+// and a function that must be called to stop receiving them.
+// Only events matching one of topics are received, where a topic also
+// matches its subtopics, so "torrent" matches "torrent.progress". No topics
+// matches everything.
+// The channel is closed when the subscriber falls too far behind.
+func (b *Bus) Subscribe(topics ...string) (<-chan Event, func()) {
+	sub := &subscriber{
+		ch:     make(chan Event, subscriberBuffer),
+		topics: topics,
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		close(sub.ch)
+		sub.closed = true
+	} else {
+		b.subs[sub] = struct{}{}
+	}
+	b.mu.Unlock()
+
+	unsubscribe := sync.OnceFunc(func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(sub)
+	})
+
+	return sub.ch, unsubscribe
+}
+
+// Close closes the channels of every subscriber, current and future, so they
+// stop listening. Events published afterwards are discarded.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subs {
+		b.remove(sub)
+	}
+}
+
+// remove must be called with b.mu held.
+func (b *Bus) remove(sub *subscriber) {
+	if sub.closed {
+		return
+	}
+
+	delete(b.subs, sub)
+	close(sub.ch)
+	sub.closed = true
+}
+
+// Publish sends an event to every subscriber without blocking. It is safe to
+// call on a nil Bus.
+func (b *Bus) Publish(topic string, data any) {
+	if b == nil {
+		return
+	}
+
+	e := Event{
+		Topic: topic,
+		Time:  time.Now(),
+		Data:  data,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs {
+		if !sub.matches(topic) {
+			continue
+		}
+
+		// tell the subscriber it missed something before it gets new
+		// events, so it can refresh
+		if sub.dropped > 0 && len(sub.ch) < cap(sub.ch)-1 {
+			sub.ch <- Event{
+				Topic: TopicDropped,
+				Time:  e.Time,
+				Data:  sub.dropped,
+			}
+			sub.dropped = 0
+		}
+
+		select {
+		case sub.ch <- e:
+		default:
+			sub.dropped++
+			slog.Debug("dropped event for slow subscriber", "topic", topic, "dropped", sub.dropped)
+
+			if sub.dropped >= maxDropped {
+				slog.Warn("closing slow subscriber", "dropped", sub.dropped)
+				b.remove(sub)
+			}
+		}
+	}
+}
+
+func (s *subscriber) matches(topic string) bool {
+	if len(s.topics) == 0 {
+		return true
+	}
+
+	for _, t := range s.topics {
+		if topic == t || strings.HasPrefix(topic, t+".") {
+			return true
+		}
+	}
+
+	return false
+}
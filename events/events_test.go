@@ -0,0 +1,83 @@
+package events
+
+import "testing"
+
+func TestSubscribeTopics(t *testing.T) {
+	bus := NewBus()
+
+	ch, unsubscribe := bus.Subscribe("torrent", "player.started")
+	defer unsubscribe()
+
+	bus.Publish("torrent.progress", 1)
+	bus.Publish("torrents.progress", 2)
+	bus.Publish("player.stopped", 3)
+	bus.Publish("player.started", 4)
+	bus.Publish("torrent", 5)
+
+	var got []any
+	for len(ch) > 0 {
+		got = append(got, (<-ch).Data)
+	}
+
+	if len(got) != 3 || got[0] != 1 || got[1] != 4 || got[2] != 5 {
+		t.Fatalf("expected [1 4 5], got %v", got)
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	bus := NewBus()
+
+	ch, unsubscribe := bus.Subscribe()
+	defer unsubscribe()
+
+	for i := range subscriberBuffer + 10 {
+		bus.Publish("test", i)
+	}
+
+	for range subscriberBuffer {
+		<-ch
+	}
+
+	bus.Publish("test", "next")
+
+	e := <-ch
+	if e.Topic != TopicDropped || e.Data != 10 {
+		t.Fatalf("expected 10 dropped events, got %+v", e)
+	}
+
+	e = <-ch
+	if e.Data != "next" {
+		t.Fatalf("expected next event, got %+v", e)
+	}
+
+	for i := range maxDropped + subscriberBuffer {
+		bus.Publish("test", i)
+	}
+
+	for range ch {
+	}
+
+	// unsubscribing a closed subscriber must not panic
+	unsubscribe()
+}
+
+func TestClose(t *testing.T) {
+	bus := NewBus()
+
+	ch, unsubscribe := bus.Subscribe()
+	defer unsubscribe()
+
+	bus.Close()
+	bus.Publish("test", 1)
+
+	if _, ok := <-ch; ok {
+		t.Fatal("expected closed channel")
+	}
+
+	late, unsubscribeLate := bus.Subscribe()
+	defer unsubscribeLate()
+
+	if _, ok := <-late; ok {
+		t.Fatal("expected closed channel for subscriber after close")
+	}
+}
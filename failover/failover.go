@@ -0,0 +1,413 @@
+// Package failover plays titles through sessions that switch to another
+// torrent of the same title when the one being played stalls.
+package failover
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/igorcafe/anyflix/db"
+	"github.com/igorcafe/anyflix/errorsx"
+	"github.com/igorcafe/anyflix/events"
+	"github.com/igorcafe/anyflix/source"
+	"github.com/igorcafe/anyflix/torrent"
+)
+
+const TopicSwitched = "failover.switched"
+
+const (
+	// DeadFor is how long a torrent marked dead is skipped, since swarms
+	// come back to life.
+	DeadFor = 24 * time.Hour
+
+	// sessionIdle is how long a session lives without requests.
+	sessionIdle = 10 * time.Minute
+)
+
+type Manager struct {
+	Torrents *torrent.Service
+	Sources  source.Finder
+	Events   *events.Bus
+
+	// StallTimeout is how long the torrent being played can go without
+	// metadata, or without downloading anything while the player waits for
+	// it, before the session switches to another torrent. Zero disables
+	// the watchdog.
+	StallTimeout time.Duration
+
+	// Restart is called when a session switches to another torrent after
+	// part of the previous one was served. The new file doesn't line up
+	// with what the player already got, so it must start over on the
+	// session stream. Sessions that were served anything are never switched
+	// while Restart is nil.
+	Restart func(sessionID string)
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// Session plays a title from the first of its streams that works.
+type Session struct {
+	ID      string          `json:"id"`
+	Kind    string          `json:"kind"`
+	VideoID string          `json:"videoId"`
+	Streams []source.Stream `json:"streams"`
+	Current int             `json:"current"`
+
+	lastRequest  time.Time
+	stalledSince time.Time
+	// served is set once a response was written for the current stream
+	served bool
+
+	// ctx is canceled when the session switches away from the current
+	// stream, so requests still waiting on it give up
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Switched is published on TopicSwitched when a session moves to another
+// stream.
+type Switched struct {
+	SessionID string        `json:"sessionId"`
+	From      source.Stream `json:"from"`
+	To        source.Stream `json:"to"`
+	Reason    string        `json:"reason"`
+	// Restarted is set when the session stream starts over, so players
+	// must reload it from the start.
+	Restarted bool `json:"restarted"`
+}
+
+func NewManager(torrents *torrent.Service, sources source.Finder) *Manager {
+	return &Manager{
+		Torrents: torrents,
+		Sources:  sources,
+		sessions: map[string]*Session{},
+	}
+}
+
+// Start creates a session playing stream, a torrent stream of the video id
+// of kind, falling back to the other torrent streams of the video in the
+// order they are found.
+func (m *Manager) Start(ctx context.Context, kind, id string, stream source.Stream) (Session, error) {
+	if stream.Kind() != source.KindTorrent {
+		return Session{}, fmt.Errorf("%w stream: only torrents can fail over", errorsx.Invalid)
+	}
+
+	streams := []source.Stream{stream}
+
+	found, err := m.Sources.Find(ctx, kind, id)
+	if err != nil {
+		slog.Warn("failed to find fallback streams", "kind", kind, "id", id, "err", err)
+	}
+
+	for _, s := range found {
+		if s.Kind() != source.KindTorrent || s.InfoHash == stream.InfoHash {
+			continue
+		}
+
+		dead, err := db.IsTorrentDead(s.InfoHash, time.Now().Add(-DeadFor))
+		if err != nil {
+			return Session{}, err
+		}
+		if !dead {
+			streams = append(streams, s)
+		}
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	s := &Session{
+		ID:          hex.EncodeToString(b),
+		Kind:        kind,
+		VideoID:     id,
+		Streams:     streams,
+		lastRequest: time.Now(),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	m.mu.Lock()
+	m.sessions[s.ID] = s
+	m.mu.Unlock()
+
+	slog.Info("started playback session", "id", s.ID, "videoId", id, "streams", len(streams))
+	return s.snapshot(), nil
+}
+
+// Get returns a session, failing with errorsx.NotFound for unknown or
+// expired ones.
+func (m *Manager) Get(id string) (Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok {
+		return Session{}, fmt.Errorf("session %s: %w", id, errorsx.NotFound)
+	}
+
+	return s.snapshot(), nil
+}
+
+// current returns the stream being played by a session, along with a
+// context canceled when the session switches away from it.
+func (m *Manager) current(id string) (source.Stream, context.Context, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok {
+		return source.Stream{}, nil, fmt.Errorf("session %s: %w", id, errorsx.NotFound)
+	}
+
+	s.lastRequest = time.Now()
+	return s.Streams[s.Current], s.ctx, nil
+}
+
+// StreamHTTP serves the stream a session is playing, like
+// torrent.Service.StreamFileHTTP. Requests waiting on a torrent whose
+// metadata never arrives are retried on the next stream. Errors are only
+// returned when nothing was written yet.
+func (m *Manager) StreamHTTP(w http.ResponseWriter, r *http.Request, id string) error {
+	for {
+		stream, streamCtx, err := m.current(id)
+		if err != nil {
+			return err
+		}
+
+		err = m.Torrents.AddTrackers(stream.InfoHash, stream.Trackers())
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		stop := context.AfterFunc(streamCtx, cancel)
+
+		sw := &servedWriter{ResponseWriter: w, served: func() { m.markServed(id, stream) }}
+		err = m.Torrents.StreamFileHTTP(sw, r.WithContext(ctx), stream.InfoHash, stream.FileIdx)
+		stop()
+		cancel()
+
+		switch {
+		case sw.wrote:
+			return err
+		case errors.Is(err, errorsx.Timeout):
+			if !m.fail(id, stream, "no metadata") {
+				return err
+			}
+		case err != nil && streamCtx.Err() != nil && r.Context().Err() == nil:
+			// switched by the watchdog while waiting
+		default:
+			return err
+		}
+	}
+}
+
+// markServed records that a response was written for the stream of a
+// session, if it's still the current one.
+func (m *Manager) markServed(id string, stream source.Stream) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if ok && s.Streams[s.Current].InfoHash == stream.InfoHash {
+		s.served = true
+	}
+}
+
+// servedWriter calls served the first time a response is written.
+type servedWriter struct {
+	http.ResponseWriter
+	served func()
+	wrote  bool
+}
+
+func (w *servedWriter) WriteHeader(status int) {
+	w.serve()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *servedWriter) Write(b []byte) (int, error) {
+	w.serve()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *servedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *servedWriter) serve() {
+	if !w.wrote {
+		w.wrote = true
+		w.served()
+	}
+}
+
+// fail marks the stream a session is playing as dead and switches to the
+// next one, restarting the player when it was already served part of the
+// stream. It reports whether there was a stream to switch to.
+func (m *Manager) fail(id string, stream source.Stream, reason string) bool {
+	err := db.MarkTorrentDead(stream.InfoHash, reason)
+	if err != nil {
+		slog.Error("failed to mark torrent dead", "infoHash", stream.InfoHash, "err", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok {
+		return false
+	}
+
+	// another request or the watchdog already moved on
+	if s.Streams[s.Current].InfoHash != stream.InfoHash {
+		return true
+	}
+
+	if s.Current+1 >= len(s.Streams) {
+		slog.Warn("no stream left to fail over to", "session", id, "infoHash", stream.InfoHash, "reason", reason)
+		return false
+	}
+
+	restart := s.served
+	if restart && m.Restart == nil {
+		slog.Warn("can't fail over a stream already being played", "session", id, "infoHash", stream.InfoHash, "reason", reason)
+		return false
+	}
+
+	s.cancel()
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.Current++
+	s.stalledSince = time.Time{}
+	s.served = false
+
+	next := s.Streams[s.Current]
+	slog.Warn("failing over to another stream",
+		"session", id,
+		"from", stream.InfoHash,
+		"to", next.InfoHash,
+		"reason", reason,
+		"restart", restart)
+
+	m.Events.Publish(TopicSwitched, Switched{
+		SessionID: id,
+		From:      stream,
+		To:        next,
+		Reason:    reason,
+		Restarted: restart,
+	})
+
+	if restart {
+		go m.Restart(id)
+	}
+
+	go func() {
+		err := m.Torrents.Drop(context.Background(), stream.InfoHash)
+		if err != nil && !errors.Is(err, errorsx.NotFound) {
+			slog.Error("failed to drop dead torrent", "infoHash", stream.InfoHash, "err", err)
+		}
+	}()
+
+	return true
+}
+
+// Watch checks the torrents played by every session each interval, failing
+// over the ones that stalled for longer than m.StallTimeout, until ctx is
+// done. It also forgets idle sessions.
+func (m *Manager) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.check(ctx, now)
+		}
+	}
+}
+
+func (m *Manager) check(ctx context.Context, now time.Time) {
+	type playing struct {
+		id     string
+		stream source.Stream
+	}
+
+	var sessions []playing
+
+	m.mu.Lock()
+	for id, s := range m.sessions {
+		if now.Sub(s.lastRequest) > sessionIdle {
+			s.cancel()
+			delete(m.sessions, id)
+			continue
+		}
+		sessions = append(sessions, playing{id, s.Streams[s.Current]})
+	}
+	m.mu.Unlock()
+
+	if m.StallTimeout <= 0 {
+		return
+	}
+
+	for _, p := range sessions {
+		stat, err := m.Torrents.Stat(ctx, p.stream.InfoHash, p.stream.FileIdx)
+		if err != nil {
+			// not requested by the player yet
+			continue
+		}
+
+		reason := stallReason(stat)
+
+		m.mu.Lock()
+		s, ok := m.sessions[p.id]
+		// the player can't be told to start over without Restart
+		if !ok || s.Streams[s.Current].InfoHash != p.stream.InfoHash || s.served && m.Restart == nil {
+			m.mu.Unlock()
+			continue
+		}
+
+		if reason == "" {
+			s.stalledSince = time.Time{}
+		} else if s.stalledSince.IsZero() {
+			s.stalledSince = now
+		}
+		stalled := reason != "" && now.Sub(s.stalledSince) >= m.StallTimeout
+		m.mu.Unlock()
+
+		if stalled {
+			m.fail(p.id, p.stream, reason)
+		}
+	}
+}
+
+// stallReason tells why a torrent can't be played, or returns "" when it's
+// fine.
+func stallReason(stat torrent.Stat) string {
+	switch {
+	case stat.BytesTotal == 0:
+		return "no metadata"
+	case stat.BytesComplete < stat.BytesTotal && stat.Buffered == 0 && stat.DownloadRate == 0:
+		return "no throughput"
+	default:
+		return ""
+	}
+}
+
+func (s *Session) snapshot() Session {
+	return Session{
+		ID:      s.ID,
+		Kind:    s.Kind,
+		VideoID: s.VideoID,
+		Streams: s.Streams,
+		Current: s.Current,
+	}
+}
@@ -0,0 +1,30 @@
+package failover
+
+import (
+	"testing"
+
+	"github.com/igorcafe/anyflix/torrent"
+)
+
+func TestStallReason(t *testing.T) {
+	tests := []struct {
+		name string
+		stat torrent.Stat
+		want string
+	}{
+		{name: "no metadata", stat: torrent.Stat{}, want: "no metadata"},
+		{name: "no throughput", stat: torrent.Stat{BytesTotal: 100}, want: "no throughput"},
+		{name: "downloading", stat: torrent.Stat{BytesTotal: 100, DownloadRate: 1}, want: ""},
+		{name: "buffered", stat: torrent.Stat{BytesTotal: 100, Buffered: 10}, want: ""},
+		{name: "complete", stat: torrent.Stat{BytesTotal: 100, BytesComplete: 100}, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := stallReason(tt.stat)
+			if got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -1,15 +1,16 @@
 package filler
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
-	"net/http"
 	"regexp"
 	"slices"
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/igorcafe/anyflix/errorsx"
+	"github.com/igorcafe/anyflix/httpx"
 )
 
 type Episode struct {
@@ -24,10 +25,10 @@ type Show struct {
 	Episodes []Episode
 }
 
-func SearchShow(query string) (Show, error) {
+func SearchShow(ctx context.Context, client *httpx.Client, query string) (Show, error) {
 	slog.Debug("filler.SearchShow", "query", query)
 
-	res, err := http.Get("https://www.animefillerlist.com/shows")
+	res, err := client.Get(ctx, "https://www.animefillerlist.com/shows")
 	if err != nil {
 		return Show{}, fmt.Errorf("failed to fetch shows: %v", err)
 	}
@@ -67,7 +68,7 @@ func SearchShow(query string) (Show, error) {
 		return Show{}, errorsx.NotFound
 	}
 
-	showRes, err := http.Get(bestMatch.URL)
+	showRes, err := client.Get(ctx, bestMatch.URL)
 	if err != nil {
 		return Show{}, fmt.Errorf("failed to fetch show page: %v", err)
 	}
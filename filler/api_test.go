@@ -1,6 +1,7 @@
 package filler
 
 import (
+	"context"
 	"testing"
 )
 
@@ -41,7 +42,7 @@ func TestSearchShow(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.query, func(t *testing.T) {
-			got, err := SearchShow(tt.query)
+			got, err := SearchShow(context.Background(), nil, tt.query)
 			if err != nil {
 				t.Fatalf("expected no error, got %v", err)
 			}
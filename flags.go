@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/igorcafe/anyflix/config"
+)
+
+// options are the settings given through flags or ANYFLIX_* environment
+// variables, flags winning. They override the config.
+type options struct {
+	offline    bool
+	addr       string
+	noBrowser  bool
+	configPath string
+	dataDir    string
+	logLevel   string
+	listenPort string
+}
+
+func parseOptions() options {
+	var o options
+
+	flag.BoolVar(&o.offline, "offline", envBool("ANYFLIX_OFFLINE"), "don't access the network, only play downloaded files")
+	flag.StringVar(&o.addr, "addr", os.Getenv("ANYFLIX_ADDR"), "host:port to serve the web interface at")
+	flag.BoolVar(&o.noBrowser, "no-browser", envBool("ANYFLIX_NO_BROWSER"), "don't open the web interface on startup")
+	flag.StringVar(&o.configPath, "config", os.Getenv("ANYFLIX_CONFIG"), "path of the config file")
+	flag.StringVar(&o.dataDir, "data-dir", os.Getenv("ANYFLIX_DATA_DIR"), "directory holding the database")
+	flag.StringVar(&o.logLevel, "log-level", os.Getenv("ANYFLIX_LOG_LEVEL"), "debug, info, warn or error")
+	flag.StringVar(&o.listenPort, "listen-port", os.Getenv("ANYFLIX_LISTEN_PORT"), "port peers connect to, 0 picks a random one")
+	flag.Parse()
+
+	return o
+}
+
+func envBool(key string) bool {
+	b, _ := strconv.ParseBool(os.Getenv(key))
+	return b
+}
+
+// override applies the options that were given to cfg, and validates the
+// result.
+func (o options) override(cfg *config.Config) error {
+	if o.addr != "" {
+		cfg.Addr = o.addr
+	}
+	if o.noBrowser {
+		cfg.NoBrowser = true
+	}
+	if o.dataDir != "" {
+		cfg.DataDir = o.dataDir
+	}
+	if o.logLevel != "" {
+		cfg.LogLevel = o.logLevel
+	}
+	if o.listenPort != "" {
+		port, err := strconv.Atoi(o.listenPort)
+		if err != nil {
+			return fmt.Errorf("invalid listen port %q: %w", o.listenPort, err)
+		}
+		cfg.Torrent.Network.ListenPort = port
+	}
+
+	_, _, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid addr %q: %w", cfg.Addr, err)
+	}
+
+	_, err = parseLogLevel(cfg.LogLevel)
+	return err
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(s))
+	if err != nil {
+		return level, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+// browserURL is the URL the web interface is reachable at from this
+// machine, given the address it's served at.
+func browserURL(addr string) string {
+	host, port, _ := net.SplitHostPort(addr)
+
+	ip := net.ParseIP(host)
+	if host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+
+	return "http://" + net.JoinHostPort(host, port)
+}
@@ -0,0 +1,160 @@
+// Package health probes the swarms of torrent streams in the background, so
+// stream listings can tell dead torrents apart.
+package health
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/igorcafe/anyflix/db"
+	"github.com/igorcafe/anyflix/errorsx"
+	"github.com/igorcafe/anyflix/events"
+	"github.com/igorcafe/anyflix/source"
+	"github.com/igorcafe/anyflix/torrent"
+)
+
+// TopicProbed is published with the db.SwarmHealth of every probed torrent.
+const TopicProbed = "health.probed"
+
+// queueSize is how many probes can wait for a worker. Listings asking for
+// more are probed the next time they are listed.
+const queueSize = 64
+
+type Prober struct {
+	Torrents *torrent.Service
+	Events   *events.Bus
+
+	// Streams is how many torrents at the top of a listing are probed. Zero
+	// disables probing.
+	Streams int
+	// Window is how long each probe looks for peers.
+	Window time.Duration
+	// MaxAge is how long a probe result is trusted.
+	MaxAge time.Duration
+
+	queue chan source.Stream
+
+	// pending holds the infohashes queued or being probed
+	mu      sync.Mutex
+	pending map[string]bool
+}
+
+func NewProber(torrents *torrent.Service) *Prober {
+	return &Prober{
+		Torrents: torrents,
+		queue:    make(chan source.Stream, queueSize),
+		pending:  map[string]bool{},
+	}
+}
+
+// Run probes the queued torrents, workers at a time, until ctx is done.
+func (p *Prober) Run(ctx context.Context, workers int) {
+	var wg sync.WaitGroup
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case s := <-p.queue:
+					p.probe(ctx, s)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// Annotate sets the cached health of the torrent streams, and queues probes
+// for the top ones whose health is unknown or too old.
+func (p *Prober) Annotate(streams []source.Stream) []source.Stream {
+	top := 0
+
+	for i, s := range streams {
+		if s.Kind() != source.KindTorrent || s.LibraryID != 0 {
+			continue
+		}
+
+		h, err := db.GetSwarmHealth(s.InfoHash)
+		if err != nil && !errors.Is(err, errorsx.NotFound) {
+			slog.Error("failed to get swarm health", "infoHash", s.InfoHash, "err", err)
+			continue
+		}
+		if err == nil {
+			streams[i].Health = &h
+		}
+
+		fresh := err == nil && time.Since(time.Unix(h.Timestamp, 0)) < p.MaxAge
+		if top < p.Streams && !fresh {
+			p.enqueue(s)
+		}
+		top++
+	}
+
+	return streams
+}
+
+func (p *Prober) enqueue(s source.Stream) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pending[s.InfoHash] {
+		return
+	}
+
+	select {
+	case p.queue <- s:
+		p.pending[s.InfoHash] = true
+	default:
+	}
+}
+
+func (p *Prober) probe(ctx context.Context, s source.Stream) {
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, s.InfoHash)
+		p.mu.Unlock()
+	}()
+
+	swarm, err := p.Torrents.ProbeSwarm(ctx, s.InfoHash, s.Trackers(), p.Window)
+	if err != nil {
+		slog.Debug("failed to probe swarm", "infoHash", s.InfoHash, "err", err)
+		return
+	}
+
+	h := db.SwarmHealth{
+		InfoHash:  s.InfoHash,
+		Peers:     swarm.Peers,
+		Connected: swarm.Connected,
+		Seeders:   swarm.Seeders,
+		Score:     Score(swarm),
+		Timestamp: time.Now().Unix(),
+	}
+
+	err = db.SaveSwarmHealth(h)
+	if err != nil {
+		slog.Error("failed to save swarm health", "infoHash", s.InfoHash, "err", err)
+		return
+	}
+
+	slog.Debug("probed swarm", "infoHash", s.InfoHash, "peers", h.Peers, "seeders", h.Seeders, "score", h.Score)
+	p.Events.Publish(TopicProbed, h)
+}
+
+// Score rates a swarm from 0 to 100. Connected seeders count the most, since
+// they prove the whole torrent is still out there, and peers that were only
+// heard of the least.
+func Score(s torrent.Swarm) int {
+	score := s.Seeders*10 + s.Connected*4 + s.Peers
+	if s.Metadata {
+		score += 10
+	}
+	return min(score, 100)
+}
@@ -0,0 +1,30 @@
+package health
+
+import (
+	"testing"
+
+	"github.com/igorcafe/anyflix/torrent"
+)
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		name  string
+		swarm torrent.Swarm
+		want  int
+	}{
+		{name: "dead", swarm: torrent.Swarm{}, want: 0},
+		{name: "heard of", swarm: torrent.Swarm{Peers: 5}, want: 5},
+		{name: "metadata", swarm: torrent.Swarm{Peers: 5, Connected: 2, Metadata: true}, want: 23},
+		{name: "seeded", swarm: torrent.Swarm{Peers: 5, Connected: 2, Seeders: 2, Metadata: true}, want: 43},
+		{name: "capped", swarm: torrent.Swarm{Peers: 200, Seeders: 50, Metadata: true}, want: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Score(tt.swarm)
+			if got != tt.want {
+				t.Fatalf("expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
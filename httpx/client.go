@@ -0,0 +1,204 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/igorcafe/anyflix/config"
+	"github.com/igorcafe/anyflix/errorsx"
+)
+
+const (
+	retryDelay    = 500 * time.Millisecond
+	maxRetryDelay = 30 * time.Second
+)
+
+// Client is the HTTP client for every outbound call. It sets the configured
+// User-Agent, applies per-host timeouts, retries requests failing with 429 or
+// 5xx and fails fast while offline. A nil *Client uses the default config.
+type Client struct {
+	userAgent    string
+	timeout      time.Duration
+	hostTimeouts map[string]time.Duration
+	retries      int
+	client       *http.Client
+}
+
+var defaultClient, _ = NewClient(config.DefaultConfig().HTTP)
+
+func NewClient(cfg config.HTTPConfig) (*Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if cfg.Proxy != "" {
+		proxy, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+
+		switch proxy.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("invalid proxy: unsupported scheme %q", proxy.Scheme)
+		}
+
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	c := &Client{
+		userAgent:    cfg.UserAgent,
+		timeout:      time.Duration(cfg.TimeoutSecs) * time.Second,
+		hostTimeouts: map[string]time.Duration{},
+		retries:      max(cfg.Retries, 0),
+		client: &http.Client{
+			Transport: OfflineTransport(transport),
+		},
+	}
+
+	for host, secs := range cfg.HostTimeoutSecs {
+		c.hostTimeouts[host] = time.Duration(secs) * time.Second
+	}
+
+	return c, nil
+}
+
+// Transport returns the round tripper behind the client, for long lived
+// requests that must not be bound by its timeouts.
+func (c *Client) Transport() http.RoundTripper {
+	if c == nil {
+		c = defaultClient
+	}
+	return c.client.Transport
+}
+
+func (c *Client) timeoutFor(host string) time.Duration {
+	if timeout, ok := c.hostTimeouts[host]; ok {
+		return timeout
+	}
+	return c.timeout
+}
+
+// Do sends req, retrying it with exponential backoff while the server answers
+// 429 or 5xx. The timeout covers reading the body too, so it must be closed.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if c == nil {
+		c = defaultClient
+	}
+
+	if req.Header.Get("User-Agent") == "" && c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+
+	parent := req.Context()
+
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := parent, context.CancelFunc(func() {})
+		if timeout := c.timeoutFor(req.URL.Hostname()); timeout > 0 {
+			ctx, cancel = context.WithTimeout(parent, timeout)
+		}
+
+		start := time.Now()
+		resp, err := c.client.Do(req.WithContext(ctx))
+
+		attrs := []any{
+			"method", req.Method,
+			"url", req.URL.Redacted(),
+			"attempt", attempt + 1,
+			"duration", time.Since(start),
+		}
+		if err != nil {
+			slog.Debug("http request failed", append(attrs, "err", err)...)
+			cancel()
+			return nil, err
+		}
+		slog.Debug("http request", append(attrs, "status", resp.StatusCode)...)
+
+		canRetry := req.Body == nil || req.GetBody != nil
+		if attempt >= c.retries || !retryable(resp.StatusCode) || !canRetry {
+			resp.Body = cancelBody{resp.Body, cancel}
+			return resp, nil
+		}
+
+		delay := backoff(attempt, resp)
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+		resp.Body.Close()
+		cancel()
+
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		select {
+		case <-parent.Done():
+			return nil, parent.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// backoff honors Retry-After, in seconds, falling back to doubling the delay
+// on every attempt.
+func backoff(attempt int, resp *http.Response) time.Duration {
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs >= 0 {
+		return min(time.Duration(secs)*time.Second, maxRetryDelay)
+	}
+	return min(retryDelay<<attempt, maxRetryDelay)
+}
+
+// cancelBody releases the request context once the body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func (c *Client) Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// GetJSON decodes the JSON response of a GET request into v. A 404 response
+// is reported as errorsx.NotFound.
+func (c *Client) GetJSON(ctx context.Context, url string, v any) error {
+	resp, err := c.Get(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("GET %s: %w", url, errorsx.NotFound)
+	}
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("GET %s: decode response: %w", url, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/igorcafe/anyflix/config"
+)
+
+func TestClientRetry(t *testing.T) {
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.UserAgent() != "anyflix-test" {
+			t.Errorf("expected configured user agent, got %q", r.UserAgent())
+		}
+
+		if hits < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(config.HTTPConfig{
+		UserAgent:   "anyflix-test",
+		TimeoutSecs: 5,
+		Retries:     2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res struct {
+		OK bool `json:"ok"`
+	}
+	err = client.GetJSON(context.Background(), srv.URL, &res)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if !res.OK || hits != 3 {
+		t.Fatalf("expected success after 3 requests, got %v after %d", res.OK, hits)
+	}
+
+	hits = 0
+	client.retries = 1
+
+	err = client.GetJSON(context.Background(), srv.URL, &res)
+	if err == nil {
+		t.Fatalf("expected error after running out of retries")
+	}
+
+	if hits != 2 {
+		t.Fatalf("expected 2 requests, got %d", hits)
+	}
+}
+
+func TestNewClientProxy(t *testing.T) {
+	for _, proxy := range []string{"http://localhost:8080", "socks5://localhost:1080"} {
+		_, err := NewClient(config.HTTPConfig{Proxy: proxy})
+		if err != nil {
+			t.Fatalf("expected %s to be accepted, got %v", proxy, err)
+		}
+	}
+
+	_, err := NewClient(config.HTTPConfig{Proxy: "ftp://localhost"})
+	if err == nil {
+		t.Fatalf("expected unsupported proxy scheme to fail")
+	}
+}
@@ -0,0 +1,85 @@
+package httpx
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/igorcafe/anyflix/events"
+)
+
+// eventsKeepAlive is how often a comment is sent to idle event streams, so
+// proxies and browsers don't close them.
+const eventsKeepAlive = 15 * time.Second
+
+// ServeEvents streams the events of bus to w as Server-Sent Events, until the
+// client goes away. The topics query parameter is a comma separated list of
+// topics to receive, see events.Bus.Subscribe.
+// Every event is sent as a JSON encoded events.Event in the data field.
+func ServeEvents(w http.ResponseWriter, r *http.Request, bus *events.Bus) {
+	var topics []string
+	for _, t := range strings.Split(r.URL.Query().Get("topics"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			topics = append(topics, t)
+		}
+	}
+
+	ch, unsubscribe := bus.Subscribe(topics...)
+	defer unsubscribe()
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	// give up on clients that stop reading instead of blocking forever
+	write := func(format string, args ...any) error {
+		_ = rc.SetWriteDeadline(time.Now().Add(eventsKeepAlive))
+		_, err := fmt.Fprintf(w, format, args...)
+		if err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if err := write("retry: 3000\n\n"); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-keepAlive.C:
+			if err := write(": keep-alive\n\n"); err != nil {
+				return
+			}
+
+		case e, ok := <-ch:
+			if !ok {
+				// dropped by the bus for being too slow, or the bus was
+				// closed on shutdown; the client is expected to reconnect
+				return
+			}
+
+			b, err := json.Marshal(e)
+			if err != nil {
+				slog.Error("failed to encode event", "topic", e.Topic, "err", err)
+				continue
+			}
+
+			if err := write("data: %s\n\n", b); err != nil {
+				slog.Debug("stopped streaming events", "err", err)
+				return
+			}
+		}
+	}
+}
@@ -2,9 +2,15 @@ package httpx
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"mime"
 	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/igorcafe/anyflix/errorsx"
 )
 
 func JSON(w http.ResponseWriter, obj any) {
@@ -40,16 +46,41 @@ func ErrorJSON(w http.ResponseWriter, params ErrorJSONParams) {
 		finalMsg = params.Msg
 	}
 
-	b, _ := json.MarshalIndent(map[string]any{
+	body := map[string]any{
 		"params.error": true,
 		"message":      finalMsg,
-	}, "", "  ")
+	}
 
 	status := params.Status
+
+	// the UI tells the user it is offline instead of showing a generic error
+	switch {
+	case errors.Is(params.Err, errorsx.Offline):
+		body["code"] = "offline"
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+	case errors.Is(params.Err, errorsx.Timeout):
+		body["code"] = "timeout"
+		if status == 0 {
+			status = http.StatusGatewayTimeout
+		}
+	case errors.Is(params.Err, errorsx.NotFound):
+		if status == 0 {
+			status = http.StatusNotFound
+		}
+	case errors.Is(params.Err, errorsx.Invalid):
+		if status == 0 {
+			status = http.StatusBadRequest
+		}
+	}
+
 	if status == 0 {
 		status = http.StatusInternalServerError
 	}
 
+	b, _ := json.MarshalIndent(body, "", "  ")
+
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(status)
 
@@ -69,9 +100,39 @@ func (w *ResponseWriter) WriteHeader(status int) {
 	w.ResponseWriter.WriteHeader(status)
 }
 
+// Unwrap lets http.ResponseController reach the wrapped writer.
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 func (w *ResponseWriter) Status() int {
 	if w.status == 0 {
 		return 200
 	}
 	return w.status
 }
+
+var videoContentTypes = map[string]string{
+	".mkv":  "video/x-matroska",
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".webm": "video/webm",
+	".avi":  "video/x-msvideo",
+	".mov":  "video/quicktime",
+	".ts":   "video/mp2t",
+	".wmv":  "video/x-ms-wmv",
+}
+
+// VideoContentType guesses the content type of a media file from its name.
+func VideoContentType(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if contentType, ok := videoContentTypes[ext]; ok {
+		return contentType
+	}
+
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType
+	}
+
+	return "application/octet-stream"
+}
@@ -0,0 +1,95 @@
+package httpx
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/igorcafe/anyflix/errorsx"
+)
+
+var (
+	offline       atomic.Bool
+	offlineForced atomic.Bool
+)
+
+// IsOffline reports whether outbound HTTP calls are currently failing fast.
+func IsOffline() bool {
+	return offline.Load()
+}
+
+// ForceOffline turns offline mode on for good, ignoring connectivity checks.
+func ForceOffline() {
+	offlineForced.Store(true)
+	setOffline(true)
+}
+
+func setOffline(v bool) {
+	if offline.Swap(v) != v {
+		slog.Info("connectivity changed", "offline", v)
+	}
+}
+
+// CheckConnectivity dials addr, a host:port, and switches offline mode
+// accordingly. It does nothing when offline mode was forced.
+func CheckConnectivity(ctx context.Context, addr string) {
+	if offlineForced.Load() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		slog.Debug("connectivity check failed", "addr", addr, "err", err)
+		setOffline(true)
+		return
+	}
+	conn.Close()
+
+	setOffline(false)
+}
+
+// WatchConnectivity checks the connectivity every interval until ctx is done.
+func WatchConnectivity(ctx context.Context, addr string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			CheckConnectivity(ctx, addr)
+		}
+	}
+}
+
+// OfflineTransport wraps base so requests fail with errorsx.Offline without
+// touching the network while offline.
+func OfflineTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return offlineTransport{base}
+}
+
+type offlineTransport struct {
+	base http.RoundTripper
+}
+
+func (t offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if IsOffline() {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("%w: %s %s", errorsx.Offline, req.Method, req.URL.Host)
+	}
+
+	return t.base.RoundTrip(req)
+}
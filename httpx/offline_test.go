@@ -0,0 +1,55 @@
+package httpx
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/igorcafe/anyflix/errorsx"
+)
+
+func TestOffline(t *testing.T) {
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: OfflineTransport(nil)}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("expected no error while online, got %v", err)
+	}
+	resp.Body.Close()
+
+	ForceOffline()
+	t.Cleanup(func() {
+		offlineForced.Store(false)
+		offline.Store(false)
+	})
+
+	_, err = client.Get(srv.URL)
+	if !errors.Is(err, errorsx.Offline) {
+		t.Fatalf("expected offline error, got %v", err)
+	}
+
+	if hits != 1 {
+		t.Fatalf("expected 1 request to reach the server, got %d", hits)
+	}
+
+	rec := httptest.NewRecorder()
+	ErrorJSON(rec, ErrorJSONParams{Err: err, Msg: "find metadata"})
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503, got %d", rec.Code)
+	}
+
+	body := map[string]any{}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if body["code"] != "offline" {
+		t.Fatalf("expected offline code, got %v", body["code"])
+	}
+}
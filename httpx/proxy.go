@@ -0,0 +1,159 @@
+package httpx
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/igorcafe/anyflix/errorsx"
+)
+
+// proxyTargetTTL is how long a registered URL can be played through the
+// proxy.
+const proxyTargetTTL = 12 * time.Hour
+
+// StreamProxy forwards requests, Range included, to registered URLs, adding
+// the headers that browsers and players can't be told to send. Only URLs
+// allowed beforehand can be registered, so it can't be used as an open proxy.
+type StreamProxy struct {
+	proxy *httputil.ReverseProxy
+
+	mu      sync.Mutex
+	allowed map[string]proxyTarget
+	targets map[string]proxyTarget
+}
+
+type proxyTarget struct {
+	url             *url.URL
+	requestHeaders  map[string]string
+	responseHeaders map[string]string
+	created         time.Time
+}
+
+func NewStreamProxy(transport http.RoundTripper) *StreamProxy {
+	p := &StreamProxy{
+		allowed: map[string]proxyTarget{},
+		targets: map[string]proxyTarget{},
+	}
+
+	p.proxy = &httputil.ReverseProxy{
+		Transport: transport,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			target := pr.In.Context().Value(proxyTargetKey{}).(proxyTarget)
+
+			u := *target.url
+			pr.Out.URL = &u
+			pr.Out.Host = ""
+
+			// don't leak anything about the local UI upstream
+			pr.Out.Header.Del("Cookie")
+			pr.Out.Header.Del("Origin")
+			pr.Out.Header.Del("Referer")
+
+			for k, v := range target.requestHeaders {
+				pr.Out.Header.Set(k, v)
+			}
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			target := resp.Request.Context().Value(proxyTargetKey{}).(proxyTarget)
+			for k, v := range target.responseHeaders {
+				resp.Header.Set(k, v)
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			ErrorJSON(w, ErrorJSONParams{
+				Err:    err,
+				Msg:    "proxy stream",
+				Status: http.StatusBadGateway,
+			})
+		},
+	}
+
+	return p
+}
+
+type proxyTargetKey struct{}
+
+// Allow lets rawURL be registered for a while, sending the given headers.
+// It's meant for the URLs of streams found by the server itself. URLs other
+// than http(s) ones are ignored.
+func (p *StreamProxy) Allow(rawURL string, requestHeaders, responseHeaders map[string]string) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pruneTargets(p.allowed)
+	p.allowed[u.String()] = proxyTarget{
+		url:             u,
+		requestHeaders:  requestHeaders,
+		responseHeaders: responseHeaders,
+		created:         time.Now(),
+	}
+}
+
+// Register makes an allowed URL playable through the proxy and returns its
+// id. It fails with errorsx.Invalid for URLs that weren't allowed.
+func (p *StreamProxy) Register(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("%w stream url %q", errorsx.Invalid, rawURL)
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	target, ok := p.allowed[u.String()]
+	if !ok || time.Since(target.created) > proxyTargetTTL {
+		return "", fmt.Errorf("%w stream url %q: not found in any stream list", errorsx.Invalid, rawURL)
+	}
+
+	pruneTargets(p.targets)
+	target.created = time.Now()
+	p.targets[id] = target
+
+	slog.Debug("registered proxied stream", "id", id, "url", u.Redacted())
+	return id, nil
+}
+
+// pruneTargets deletes the targets older than proxyTargetTTL.
+func pruneTargets(targets map[string]proxyTarget) {
+	for key, target := range targets {
+		if time.Since(target.created) > proxyTargetTTL {
+			delete(targets, key)
+		}
+	}
+}
+
+// ServeHTTP proxies the URL registered under the "id" path value.
+func (p *StreamProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	target, ok := p.targets[r.PathValue("id")]
+	p.mu.Unlock()
+
+	if !ok || time.Since(target.created) > proxyTargetTTL {
+		ErrorJSON(w, ErrorJSONParams{
+			Msg:    "proxied stream not found",
+			Status: http.StatusNotFound,
+		})
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), proxyTargetKey{}, target)
+	p.proxy.ServeHTTP(w, r.WithContext(ctx))
+}
@@ -0,0 +1,83 @@
+package httpx
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/igorcafe/anyflix/errorsx"
+)
+
+func TestStreamProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Referer") != "https://example.com" {
+			t.Errorf("expected proxy header, got %q", r.Header.Get("Referer"))
+		}
+		if r.Header.Get("Range") != "bytes=2-" {
+			t.Errorf("expected range to be forwarded, got %q", r.Header.Get("Range"))
+		}
+
+		w.Header().Set("Content-Range", "bytes 2-5/6")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte("cdef"))
+	}))
+	defer upstream.Close()
+
+	p := NewStreamProxy(http.DefaultTransport)
+
+	_, err := p.Register(upstream.URL + "/video.mp4")
+	if !errors.Is(err, errorsx.Invalid) {
+		t.Fatalf("expected URL that wasn't allowed to be rejected, got %v", err)
+	}
+
+	p.Allow(upstream.URL+"/video.mp4",
+		map[string]string{"Referer": "https://example.com"},
+		map[string]string{"Access-Control-Allow-Origin": "*"})
+
+	id, err := p.Register(upstream.URL + "/video.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /proxy/{id}", p)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/proxy/"+id, nil)
+	req.Header.Set("Range", "bytes=2-")
+	req.Header.Set("Referer", "http://localhost:2025/details")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "cdef" {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode, body)
+	}
+
+	if resp.Header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("expected response header to be set")
+	}
+
+	resp, err = http.Get(srv.URL + "/proxy/unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown id, got %d", resp.StatusCode)
+	}
+
+	p.Allow("file:///etc/passwd", nil, nil)
+	_, err = p.Register("file:///etc/passwd")
+	if err == nil {
+		t.Fatalf("expected non http url to be rejected")
+	}
+}
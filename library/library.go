@@ -0,0 +1,308 @@
+// Package library indexes local media files and matches them to titles.
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/igorcafe/anyflix/db"
+	"github.com/igorcafe/anyflix/errorsx"
+	"github.com/igorcafe/anyflix/events"
+	"github.com/igorcafe/anyflix/meta"
+	"github.com/igorcafe/anyflix/source"
+)
+
+// DebounceDelay is how long a file must go without writes before the
+// watcher indexes it.
+var DebounceDelay = 10 * time.Second
+
+const (
+	TopicAdded   = "library.added"
+	TopicRemoved = "library.removed"
+	TopicMoved   = "library.moved"
+)
+
+type Library struct {
+	Dirs   []string
+	Meta   meta.API
+	Events *events.Bus
+
+	// scanMu serializes scans, matchMu guards matches
+	scanMu  sync.Mutex
+	matchMu sync.Mutex
+	matches map[string]string
+}
+
+func New(dirs []string, metaAPI meta.API) *Library {
+	return &Library{
+		Dirs:    dirs,
+		Meta:    metaAPI,
+		matches: map[string]string{},
+	}
+}
+
+// Scan walks every library directory, indexing new or changed video files and
+// removing entries whose files are gone.
+func (l *Library) Scan(ctx context.Context) error {
+	l.scanMu.Lock()
+	defer l.scanMu.Unlock()
+
+	slog.Info("scanning library", "dirs", l.Dirs)
+
+	seen := map[string]bool{}
+
+	for _, dir := range l.Dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				slog.Warn("skipping library path", "path", path, "err", err)
+				return nil
+			}
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if d.IsDir() || !IsVideo(path) {
+				return nil
+			}
+
+			seen[path] = true
+
+			_, err = l.Add(ctx, path)
+			if err != nil {
+				slog.Error("failed to index library file", "path", path, "err", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	entries, err := db.ListLibrary()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if seen[e.Path] || !l.contains(e.Path) {
+			continue
+		}
+
+		if _, err := os.Stat(e.Path); errors.Is(err, fs.ErrNotExist) {
+			err = l.Remove(e.Path)
+			if err != nil {
+				return err
+			}
+			slog.Debug("removed library file", "path", e.Path)
+		}
+	}
+
+	slog.Info("scanned library", "files", len(seen))
+	return nil
+}
+
+func (l *Library) contains(path string) bool {
+	for _, dir := range l.Dirs {
+		rel, err := filepath.Rel(dir, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add indexes a single file, skipping the title lookup when the file didn't
+// change since it was last indexed.
+func (l *Library) Add(ctx context.Context, path string) (db.LibraryEntry, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return db.LibraryEntry{}, err
+	}
+
+	existing, err := db.FindLibraryEntry(path)
+	if err == nil && existing.Size == stat.Size() && existing.ModTime == stat.ModTime().Unix() {
+		return existing, nil
+	}
+	if err != nil && !errors.Is(err, errorsx.NotFound) {
+		return db.LibraryEntry{}, err
+	}
+
+	parsed := ParsePath(path)
+
+	e := db.LibraryEntry{
+		Path:    path,
+		Kind:    parsed.Kind,
+		Season:  parsed.Season,
+		Episode: parsed.Episode,
+		Title:   parsed.Title,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime().Unix(),
+	}
+
+	e.IMDBID, err = l.match(ctx, parsed)
+	if err != nil {
+		return db.LibraryEntry{}, err
+	}
+
+	e.ID, err = db.SaveLibraryEntry(e)
+	if err != nil {
+		return db.LibraryEntry{}, err
+	}
+
+	slog.Debug("indexed library file", "path", path, "imdbID", e.IMDBID, "title", e.Title)
+	l.Events.Publish(TopicAdded, e)
+	return e, nil
+}
+
+// match finds the IMDb id of a parsed title, remembering the answer since
+// every episode of a show asks the same question.
+func (l *Library) match(ctx context.Context, p Parsed) (string, error) {
+	if p.Title == "" {
+		return "", nil
+	}
+
+	key := fmt.Sprintf("%s/%s/%d", p.Kind, strings.ToLower(p.Title), p.Year)
+
+	l.matchMu.Lock()
+	id, ok := l.matches[key]
+	l.matchMu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	metas, err := l.Meta.Search(ctx, p.Kind, p.Title)
+	if err != nil {
+		return "", err
+	}
+
+	for _, m := range metas {
+		if p.Year == 0 || strings.HasPrefix(m.ReleaseInfo, strconv.Itoa(p.Year)) {
+			id = m.ID
+			break
+		}
+	}
+
+	if id == "" && len(metas) > 0 {
+		id = metas[0].ID
+	}
+
+	l.matchMu.Lock()
+	l.matches[key] = id
+	l.matchMu.Unlock()
+
+	return id, nil
+}
+
+// Remove drops the file at path from the library. Nothing is published when
+// it wasn't indexed.
+func (l *Library) Remove(path string) error {
+	n, err := db.DeleteLibraryEntry(path)
+	if err != nil || n == 0 {
+		return err
+	}
+
+	l.Events.Publish(TopicRemoved, map[string]string{"path": path})
+	return nil
+}
+
+// Find lists the local files of a title. id is a stremio video id, like
+// tt0944947:1:2 for episodes.
+func (l *Library) Find(ctx context.Context, kind, id string) ([]source.Stream, error) {
+	imdbID, season, episode, err := source.ParseVideoID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := db.FindLibraryVideo(imdbID, season, episode)
+	if err != nil {
+		return nil, err
+	}
+
+	streams := []source.Stream{}
+	for _, e := range entries {
+		streams = append(streams, source.Stream{
+			Type:      source.KindLocal,
+			Name:      "local",
+			Title:     fmt.Sprintf("%s\n%.2f GB", filepath.Base(e.Path), float64(e.Size)/1e9),
+			LibraryID: e.ID,
+		})
+	}
+
+	return streams, nil
+}
+
+// AddDownload indexes a file downloaded from a torrent and links them, so the
+// local copy can be used instead of streaming the torrent again.
+func (l *Library) AddDownload(ctx context.Context, path, infoHash string, fileIdx int) error {
+	_, err := l.Add(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	return db.LinkLibraryTorrent(path, infoHash, fileIdx)
+}
+
+// LocalCopy returns the library entry holding a complete copy of a torrent
+// file, if it is still on disk.
+func (l *Library) LocalCopy(infoHash string, fileIdx int) (db.LibraryEntry, bool) {
+	e, err := db.FindLibraryTorrent(infoHash, fileIdx)
+	if err != nil {
+		if !errors.Is(err, errorsx.NotFound) {
+			slog.Error("failed to find local copy", "infoHash", infoHash, "err", err)
+		}
+		return e, false
+	}
+
+	stat, err := os.Stat(e.Path)
+	if err != nil || stat.Size() != e.Size {
+		return e, false
+	}
+
+	return e, true
+}
+
+// PreferLocal links torrent streams to their local copies and moves every
+// stream that can be played from disk to the top, dropping plain library
+// streams for files that are already linked to a torrent stream.
+func (l *Library) PreferLocal(streams []source.Stream) []source.Stream {
+	linked := map[int64]bool{}
+
+	for i, s := range streams {
+		if s.InfoHash == "" {
+			continue
+		}
+
+		if e, ok := l.LocalCopy(s.InfoHash, s.FileIdx); ok {
+			streams[i].LibraryID = e.ID
+			linked[e.ID] = true
+		}
+	}
+
+	streams = slices.DeleteFunc(streams, func(s source.Stream) bool {
+		return s.InfoHash == "" && linked[s.LibraryID]
+	})
+
+	slices.SortStableFunc(streams, func(a, b source.Stream) int {
+		switch {
+		case a.LibraryID != 0 && b.LibraryID == 0:
+			return -1
+		case a.LibraryID == 0 && b.LibraryID != 0:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	return streams
+}
@@ -0,0 +1,127 @@
+package library
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var videoExts = []string{".mkv", ".mp4", ".m4v", ".avi", ".webm", ".mov", ".ts", ".wmv"}
+
+// IsVideo reports whether path looks like a video file worth indexing.
+func IsVideo(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, videoExt := range videoExts {
+		if ext == videoExt {
+			return !strings.Contains(strings.ToLower(filepath.Base(path)), "sample")
+		}
+	}
+	return false
+}
+
+// Parsed is what can be told about a video from its path.
+type Parsed struct {
+	Kind    string
+	Title   string
+	Year    int
+	Season  int
+	Episode int
+}
+
+var (
+	episodeRe = regexp.MustCompile(`(?i)(?:^|[\s._\-\[(])s(\d{1,2})[\s._\-]?e(\d{1,3})(?:[^\d]|$)`)
+	crossRe   = regexp.MustCompile(`(?i)(?:^|[\s._\-\[(])(\d{1,2})x(\d{2,3})(?:[^\d]|$)`)
+	yearRe    = regexp.MustCompile(`(?:^|[\s._\-\[(])((?:19|20)\d{2})(?:[\s._\-\])]|$)`)
+	seasonRe  = regexp.MustCompile(`(?i)^(season|s)[\s._\-]?\d+$`)
+	junkRe    = regexp.MustCompile(`(?i)[\s._\-\[(](2160p|1080p|720p|480p|4k|web[\s._\-]?dl|webrip|bluray|brrip|hdtv|x264|x265|h264|h265|hevc|dvdrip|remux)\b.*$`)
+	spaceRe   = regexp.MustCompile(`\s+`)
+)
+
+// ParsePath parses movie names like "Movie.Name.2010.1080p.mkv" and episode
+// names like "Show Name S01E02.mkv". When the file name has no title before
+// the episode marker, the closest parent directory that isn't a season folder
+// is used instead.
+func ParsePath(path string) Parsed {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	if loc, season, episode := findEpisode(name); loc != nil {
+		p := Parsed{
+			Kind:    "series",
+			Title:   cleanTitle(name[:loc[0]]),
+			Season:  season,
+			Episode: episode,
+		}
+
+		if p.Title == "" {
+			p.Title = showDir(path)
+		}
+
+		p.Title, p.Year = splitYear(p.Title)
+		return p
+	}
+
+	p := Parsed{Kind: "movie"}
+	p.Title, p.Year = splitYear(name)
+	if p.Title == "" {
+		p.Title = cleanTitle(name)
+	}
+
+	return p
+}
+
+// findEpisode returns the location of the episode marker in name.
+func findEpisode(name string) ([]int, int, int) {
+	for _, re := range []*regexp.Regexp{episodeRe, crossRe} {
+		m := re.FindStringSubmatchIndex(name)
+		if m == nil {
+			continue
+		}
+
+		season, _ := strconv.Atoi(name[m[2]:m[3]])
+		episode, _ := strconv.Atoi(name[m[4]:m[5]])
+		return m, season, episode
+	}
+
+	return nil, 0, 0
+}
+
+// splitYear cuts s at the release year, returning the cleaned title before it.
+func splitYear(s string) (string, int) {
+	matches := yearRe.FindAllStringSubmatchIndex(s, -1)
+
+	// the last year is the release year, like in "2001 A Space Odyssey 1968"
+	for i := len(matches) - 1; i >= 0; i-- {
+		m := matches[i]
+		title := cleanTitle(s[:m[0]])
+		if title == "" {
+			continue
+		}
+
+		year, _ := strconv.Atoi(s[m[2]:m[3]])
+		return title, year
+	}
+
+	return cleanTitle(s), 0
+}
+
+func cleanTitle(s string) string {
+	s = junkRe.ReplaceAllString(s, "")
+	s = strings.NewReplacer(".", " ", "_", " ").Replace(s)
+	s = strings.Trim(s, " -[(")
+	return spaceRe.ReplaceAllString(s, " ")
+}
+
+func showDir(path string) string {
+	dir := filepath.Dir(path)
+
+	for dir != "." && dir != string(filepath.Separator) {
+		name := cleanTitle(filepath.Base(dir))
+		if name != "" && !seasonRe.MatchString(name) {
+			return name
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	return ""
+}
@@ -0,0 +1,60 @@
+package library
+
+import (
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want Parsed
+	}{
+		{
+			path: "/media/Inception.2010.1080p.BluRay.x264.mkv",
+			want: Parsed{Kind: "movie", Title: "Inception", Year: 2010},
+		},
+		{
+			path: "/media/2001 A Space Odyssey (1968).mp4",
+			want: Parsed{Kind: "movie", Title: "2001 A Space Odyssey", Year: 1968},
+		},
+		{
+			path: "/media/Some Movie.avi",
+			want: Parsed{Kind: "movie", Title: "Some Movie"},
+		},
+		{
+			path: "/media/Breaking.Bad.S02E10.720p.HDTV.mkv",
+			want: Parsed{Kind: "series", Title: "Breaking Bad", Season: 2, Episode: 10},
+		},
+		{
+			path: "/media/The Office (2005) - 3x07 - Branch Wars.mkv",
+			want: Parsed{Kind: "series", Title: "The Office", Year: 2005, Season: 3, Episode: 7},
+		},
+		{
+			path: "/media/Dark/Season 1/S01E03.mkv",
+			want: Parsed{Kind: "series", Title: "Dark", Season: 1, Episode: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got := ParsePath(tt.path)
+			if got != tt.want {
+				t.Fatalf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestIsVideo(t *testing.T) {
+	if !IsVideo("/media/movie.MKV") {
+		t.Fatalf("expected mkv to be a video")
+	}
+
+	if IsVideo("/media/movie.srt") {
+		t.Fatalf("expected srt not to be a video")
+	}
+
+	if IsVideo("/media/movie-sample.mkv") {
+		t.Fatalf("expected samples to be skipped")
+	}
+}
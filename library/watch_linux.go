@@ -0,0 +1,307 @@
+package library
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/igorcafe/anyflix/db"
+)
+
+const watchMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE
+
+// movedFrom is the first half of a rename, waiting for its IN_MOVED_TO.
+type movedFrom struct {
+	path  string
+	isDir bool
+	at    time.Time
+}
+
+type watcher struct {
+	lib  *Library
+	fd   int
+	file *os.File
+	dirs map[int32]string
+
+	// pending maps files being written to the time of their last write,
+	// ready holds the ones waiting for the indexer
+	pending map[string]time.Time
+	ready   []string
+	moves   map[uint32]movedFrom
+}
+
+// Watch follows changes in the library directories until ctx is done, adding,
+// moving and removing entries as files change. Files are only indexed once
+// they haven't been written to for DebounceDelay.
+func (l *Library) Watch(ctx context.Context) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+
+	w := &watcher{
+		lib:     l,
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		dirs:    map[int32]string{},
+		pending: map[string]time.Time{},
+		moves:   map[uint32]movedFrom{},
+	}
+	defer w.file.Close()
+
+	for _, dir := range l.Dirs {
+		err = w.addTree(dir, false)
+		if err != nil {
+			slog.Warn("failed to watch library dir", "dir", dir, "err", err)
+		}
+	}
+
+	raw := make(chan []byte)
+	readErr := make(chan error, 1)
+
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := w.file.Read(buf)
+			if err != nil {
+				readErr <- err
+				return
+			}
+
+			b := bytes.Clone(buf[:n])
+			select {
+			case raw <- b:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// indexing looks titles up over the network, so it's left to another
+	// goroutine to keep reading events meanwhile
+	index := make(chan string)
+	defer close(index)
+
+	go func() {
+		for path := range index {
+			_, err := l.Add(ctx, path)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				slog.Error("failed to index library file", "path", path, "err", err)
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	slog.Info("watching library", "dirs", l.Dirs)
+
+	for {
+		// only send when there's something to index
+		var send chan string
+		var next string
+		if len(w.ready) > 0 {
+			send, next = index, w.ready[0]
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-readErr:
+			return err
+		case b := <-raw:
+			w.handle(b)
+		case now := <-ticker.C:
+			w.flush(now)
+		case send <- next:
+			w.ready = w.ready[1:]
+		}
+	}
+}
+
+// addTree watches dir and its subdirectories. When index is set, the video
+// files found are queued to be indexed, as happens for directories created or
+// moved in after the initial scan.
+func (w *watcher) addTree(dir string, index bool) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		if !d.IsDir() {
+			if index && IsVideo(path) {
+				w.pending[path] = time.Now()
+			}
+			return nil
+		}
+
+		wd, err := syscall.InotifyAddWatch(w.fd, path, watchMask)
+		if err != nil {
+			slog.Warn("failed to watch dir", "dir", path, "err", err)
+			return nil
+		}
+
+		w.dirs[int32(wd)] = path
+		return nil
+	})
+}
+
+func (w *watcher) handle(b []byte) {
+	for len(b) >= syscall.SizeofInotifyEvent {
+		ev := (*syscall.InotifyEvent)(unsafe.Pointer(&b[0]))
+		end := syscall.SizeofInotifyEvent + int(ev.Len)
+		if end > len(b) {
+			return
+		}
+
+		name := strings.TrimRight(string(b[syscall.SizeofInotifyEvent:end]), "\x00")
+		b = b[end:]
+
+		if ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+			slog.Warn("library watcher queue overflowed, rescanning")
+			go w.lib.Scan(context.Background())
+			continue
+		}
+
+		if ev.Mask&syscall.IN_IGNORED != 0 {
+			delete(w.dirs, ev.Wd)
+			continue
+		}
+
+		dir, ok := w.dirs[ev.Wd]
+		if !ok || name == "" {
+			continue
+		}
+
+		w.handleEvent(filepath.Join(dir, name), ev.Mask, ev.Cookie)
+	}
+}
+
+func (w *watcher) handleEvent(path string, mask uint32, cookie uint32) {
+	isDir := mask&syscall.IN_ISDIR != 0
+
+	switch {
+	case mask&syscall.IN_MOVED_FROM != 0:
+		w.moves[cookie] = movedFrom{path: path, isDir: isDir, at: time.Now()}
+		delete(w.pending, path)
+
+	case mask&syscall.IN_MOVED_TO != 0:
+		from, ok := w.moves[cookie]
+		delete(w.moves, cookie)
+
+		if isDir {
+			if ok {
+				w.move(from.path, path)
+			}
+			// new watches are needed either way, the old ones refer to the
+			// directory's previous location
+			w.addTree(path, !ok)
+			return
+		}
+
+		if ok && IsVideo(from.path) && IsVideo(path) {
+			w.move(from.path, path)
+			return
+		}
+
+		if ok {
+			w.remove(from.path, false)
+		}
+		if IsVideo(path) {
+			w.pending[path] = time.Now()
+		}
+
+	case mask&syscall.IN_DELETE != 0:
+		delete(w.pending, path)
+		w.remove(path, isDir)
+
+	case isDir && mask&syscall.IN_CREATE != 0:
+		w.addTree(path, true)
+
+	case mask&(syscall.IN_CREATE|syscall.IN_MODIFY|syscall.IN_CLOSE_WRITE) != 0:
+		if IsVideo(path) {
+			w.pending[path] = time.Now()
+		}
+	}
+}
+
+func (w *watcher) move(from, to string) {
+	err := db.MoveLibraryEntries(from, to)
+	if err != nil {
+		slog.Error("failed to move library entries", "from", from, "to", to, "err", err)
+		return
+	}
+
+	slog.Debug("moved library entries", "from", from, "to", to)
+	w.lib.Events.Publish(TopicMoved, map[string]string{"from": from, "to": to})
+}
+
+func (w *watcher) remove(path string, isDir bool) {
+	if !isDir {
+		if !IsVideo(path) {
+			return
+		}
+
+		err := w.lib.Remove(path)
+		if err != nil {
+			slog.Error("failed to remove library entry", "path", path, "err", err)
+		}
+		return
+	}
+
+	w.unwatchTree(path)
+
+	n, err := db.DeleteLibraryTree(path)
+	if err != nil {
+		slog.Error("failed to remove library entries", "path", path, "err", err)
+		return
+	}
+
+	if n > 0 {
+		w.lib.Events.Publish(TopicRemoved, map[string]string{"path": path})
+	}
+}
+
+// unwatchTree stops watching dir and its subdirectories, which is needed when
+// they are moved out of the library.
+func (w *watcher) unwatchTree(dir string) {
+	for wd, path := range w.dirs {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.dirs, wd)
+		}
+	}
+}
+
+// flush queues files that stopped changing to be indexed and treats renames
+// whose target never showed up as files moved out of the library.
+func (w *watcher) flush(now time.Time) {
+	for path, last := range w.pending {
+		if now.Sub(last) < DebounceDelay {
+			continue
+		}
+		delete(w.pending, path)
+
+		if !slices.Contains(w.ready, path) {
+			w.ready = append(w.ready, path)
+		}
+	}
+
+	for cookie, from := range w.moves {
+		if now.Sub(from.at) < time.Second {
+			continue
+		}
+		delete(w.moves, cookie)
+		w.remove(from.path, from.isDir)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package library
+
+import (
+	"context"
+	"errors"
+)
+
+// Watch is only implemented on linux, elsewhere the library is refreshed by
+// scanning.
+func (l *Library) Watch(ctx context.Context) error {
+	return errors.New("library watching is not supported on this platform")
+}
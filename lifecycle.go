@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/igorcafe/anyflix/db"
+	"github.com/igorcafe/anyflix/player"
+	"github.com/igorcafe/anyflix/torrent"
+)
+
+// drainTimeout is how long requests in flight, like streams, and players get
+// to finish on shutdown.
+const drainTimeout = 10 * time.Second
+
+// shutdown stops the server and the players, saves the downloads in
+// progress and the seeding progress, and closes the torrent client and the db. Every step runs even
+// when an earlier one fails.
+func shutdown(srv *http.Server, videoPlayer *player.Player, torrents *torrent.Service) error {
+	slog.Info("shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	var errs []error
+
+	// the players hold most streams open, so they're stopped while draining
+	drained := make(chan error, 1)
+	go func() {
+		drained <- srv.Shutdown(ctx)
+	}()
+
+	err := videoPlayer.Stop(ctx)
+	if err != nil {
+		errs = append(errs, err)
+		slog.Error("failed to stop players", "err", err)
+	}
+
+	err = <-drained
+	if err != nil {
+		slog.Warn("closing requests that didn't finish", "err", err)
+		_ = srv.Close()
+	}
+
+	pending := []db.PendingDownload{}
+	for _, d := range torrents.PendingDownloads() {
+		pending = append(pending, db.PendingDownload{InfoHash: d.InfoHash, FileIdx: d.FileIdx})
+	}
+
+	err = db.SavePendingDownloads(pending)
+	if err != nil {
+		errs = append(errs, err)
+		slog.Error("failed to save pending downloads", "err", err)
+	}
+
+	seeding := []db.SeedingState{}
+	for _, s := range torrents.SeedingStates() {
+		seeding = append(seeding, db.SeedingState(s))
+	}
+
+	err = db.SaveSeedingStates(seeding)
+	if err != nil {
+		errs = append(errs, err)
+		slog.Error("failed to save seeding progress", "err", err)
+	}
+
+	err = torrents.UnforwardPort()
+	if err != nil {
+		// the mappings stay on the devices, which isn't worth failing for
+		slog.Warn("failed to remove some port mappings", "err", err)
+	}
+
+	err = torrents.Close()
+	if err != nil {
+		errs = append(errs, err)
+		slog.Error("failed to close torrent client", "err", err)
+	}
+
+	err = db.Close()
+	if err != nil {
+		errs = append(errs, err)
+		slog.Error("failed to close db", "err", err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// restoreSeeding picks up the seeding progress saved by the last shutdown.
+func restoreSeeding(torrents *torrent.Service) {
+	saved, err := db.ListSeedingStates()
+	if err != nil {
+		slog.Error("failed to list seeding progress", "err", err)
+		return
+	}
+
+	states := []torrent.SeedingState{}
+	for _, s := range saved {
+		states = append(states, torrent.SeedingState(s))
+	}
+	torrents.RestoreSeeding(states)
+}
+
+// resumeDownloads restarts the downloads interrupted by the last shutdown.
+// The ones that fail are tried again on the next start.
+func resumeDownloads(ctx context.Context, torrents *torrent.Service) {
+	pending, err := db.ListPendingDownloads()
+	if err != nil {
+		slog.Error("failed to list pending downloads", "err", err)
+		return
+	}
+
+	for _, d := range pending {
+		go func() {
+			err := torrents.DownloadFile(ctx, d.InfoHash, d.FileIdx)
+			if err != nil {
+				slog.Error("failed to resume download", "infoHash", d.InfoHash, "fileIdx", d.FileIdx, "err", err)
+				return
+			}
+			slog.Info("resumed download", "infoHash", d.InfoHash, "fileIdx", d.FileIdx)
+		}()
+	}
+}
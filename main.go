@@ -1,23 +1,42 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"embed"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime/debug"
 	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/igorcafe/anyflix/config"
 	"github.com/igorcafe/anyflix/db"
+	"github.com/igorcafe/anyflix/errorsx"
+	"github.com/igorcafe/anyflix/events"
+	"github.com/igorcafe/anyflix/failover"
+	"github.com/igorcafe/anyflix/health"
 	"github.com/igorcafe/anyflix/httpx"
+	"github.com/igorcafe/anyflix/library"
 	"github.com/igorcafe/anyflix/meta"
+	"github.com/igorcafe/anyflix/mkv"
 	"github.com/igorcafe/anyflix/opensubs"
+	"github.com/igorcafe/anyflix/player"
+	"github.com/igorcafe/anyflix/probe"
 	"github.com/igorcafe/anyflix/source"
 	"github.com/igorcafe/anyflix/torrent"
 	_ "modernc.org/sqlite"
@@ -26,34 +45,141 @@ import (
 //go:embed www/*
 var www embed.FS
 
+// connectivityAddr is dialed to tell whether anyflix is online.
+const connectivityAddr = "v3-cinemeta.strem.io:443"
+
 func main() {
+	opts := parseOptions()
+
+	// cancelled on SIGINT or SIGTERM, which starts the shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// the config decides the level once loaded, unless it's overridden
 	slog.SetLogLoggerLevel(slog.LevelDebug)
+	if level, err := parseLogLevel(opts.logLevel); err == nil {
+		slog.SetLogLoggerLevel(level)
+	}
+
+	if opts.offline {
+		httpx.ForceOffline()
+	} else {
+		httpx.CheckConnectivity(context.Background(), connectivityAddr)
+		go httpx.WatchConnectivity(ctx, connectivityAddr, 30*time.Second)
+	}
+	if httpx.IsOffline() {
+		slog.Warn("running in offline mode")
+	}
+
+	cfgPath := opts.configPath
+	if cfgPath == "" {
+		path, err := config.DefaultPath()
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfgPath = path
+	}
+
+	slog.Info("loading config", "path", cfgPath)
+	fileCfg, err := config.Load(cfgPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// fileCfg is what gets saved back, so the options aren't made permanent
+	cfg := fileCfg
+	err = opts.override(&cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	level, _ := parseLogLevel(cfg.LogLevel)
+	slog.SetLogLoggerLevel(level)
+
+	// before anything that runs in the background, most of which uses it
+	err = db.Init(filepath.Join(cfg.DataDir, "anyflix.db"))
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	slog.Info("loading config")
-	cfg, err := config.Load()
+	// guards fileCfg for the handlers that change it at runtime
+	var cfgMu sync.Mutex
+
+	httpClient, err := httpx.NewClient(cfg.HTTP)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	metaAPI := meta.DefaultAPI()
+	metaAPI.HTTP = httpClient
+	metaAPI.Cache = db.MetaCache{}
 	opensubtitles := opensubs.DefaultAPI()
+	opensubtitles.HTTP = httpClient
+
+	bus := events.NewBus()
+
+	// the download dir isn't scanned since it holds partial files, finished
+	// downloads are added through OnDownloaded instead
+	lib := library.New(cfg.LibraryDirs, metaAPI)
+	lib.Events = bus
 
 	torrentSource := source.SourceMux{
 		Addons: cfg.Addons,
+		Local:  []source.Finder{lib, source.Manual{}},
+		HTTP:   httpClient,
 	}
 
 	slog.Info("starting torrent service")
-	torrentService, err := torrent.DefaultService()
+	torrentService, err := torrent.DefaultService(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
 	slog.Info("started torrent service")
 
-	cacheDir, err := os.UserCacheDir()
-	if err != nil {
-		log.Fatal(err)
+	torrentService.Events = bus
+	go torrentService.PublishEvents(ctx, 2*time.Second)
+
+	torrentService.SeedingPolicies = db.ListSeedingPolicies
+	restoreSeeding(torrentService)
+	go torrentService.EnforceSeeding(ctx, 30*time.Second)
+
+	torrentService.OnDownloaded = func(path, infoHash string, fileIdx int) {
+		err := lib.AddDownload(context.Background(), path, infoHash, fileIdx)
+		if err != nil {
+			slog.Error("failed to add download to library", "path", path, "err", err)
+		}
+
+		err = db.DeletePendingDownload(infoHash, fileIdx)
+		if err != nil {
+			slog.Error("failed to forget finished download", "infoHash", infoHash, "fileIdx", fileIdx, "err", err)
+		}
+	}
+
+	torrentService.OnDeleted = func(path, infoHash string, fileIdx int) {
+		err := lib.Remove(path)
+		if err != nil {
+			slog.Error("failed to remove deleted file from library", "path", path, "err", err)
+		}
+	}
+
+	streamProxy := httpx.NewStreamProxy(httpClient.Transport())
+
+	prober := health.NewProber(torrentService)
+	prober.Events = bus
+	prober.Streams = cfg.Torrent.HealthProbeStreams
+	prober.Window = time.Duration(cfg.Torrent.HealthProbeSecs) * time.Second
+	prober.MaxAge = time.Duration(cfg.Torrent.HealthMaxAgeMins) * time.Minute
+	go prober.Run(ctx, 3)
+
+	sessions := failover.NewManager(torrentService, torrentSource)
+	sessions.Events = bus
+	sessions.StallTimeout = time.Duration(cfg.Torrent.FailoverTimeoutSecs) * time.Second
+	go sessions.Watch(ctx, 5*time.Second)
+
+	videoPlayer := &player.Player{
+		Cmd:    cfg.PlayerCmd,
+		Events: bus,
 	}
-	_ = cacheDir
 
 	routesMux := http.NewServeMux()
 	mux := http.NewServeMux()
@@ -62,7 +188,7 @@ func main() {
 		wx := &httpx.ResponseWriter{ResponseWriter: w}
 		w = wx
 		slog.Debug(fmt.Sprintf("[INC] - %s %s", r.Method, r.URL.Path))
-		routesMux.ServeHTTP(w, r)
+		recoverPanics(routesMux).ServeHTTP(w, r)
 		slog.Debug(fmt.Sprintf("[%d] - %s %s", wx.Status(), r.Method, r.URL.Path))
 	})
 
@@ -71,14 +197,39 @@ func main() {
 		log.Fatal(err)
 	}
 
-	err = db.Init()
+	go resumeDownloads(ctx, torrentService)
+
+	go func() {
+		err := lib.Scan(ctx)
+		if err != nil {
+			slog.Error("failed to scan library", "err", err)
+		}
+
+		err = lib.Watch(ctx)
+		if err != nil {
+			slog.Error("failed to watch library", "err", err)
+		}
+	}()
+
+	// listening right away gives the actual port when cfg.Addr has port 0
+	ln, err := net.Listen("tcp", cfg.Addr)
 	if err != nil {
 		log.Fatal(err)
 	}
+	baseURL := browserURL(ln.Addr().String())
 
-	host := "localhost"
-	port := 2025
-	baseURL := fmt.Sprintf("http://%s:%d", host, port)
+	// a session that switched torrents mid playback is played from the start
+	sessions.Restart = func(id string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		err := videoPlayer.Restart(ctx, player.Params{
+			URL: fmt.Sprintf("%s/api/sessions/%s/stream", baseURL, id),
+		})
+		if err != nil {
+			slog.Error("failed to restart player", "session", id, "err", err)
+		}
+	}
 
 	routesMux.Handle("GET /", http.FileServerFS(www))
 
@@ -86,6 +237,16 @@ func main() {
 	// routesMux.Handle("GET /", http.FileServer(http.Dir("./www")))
 	// _ = www
 
+	routesMux.HandleFunc("GET /api/status", func(w http.ResponseWriter, r *http.Request) {
+		httpx.JSON(w, map[string]any{
+			"offline": httpx.IsOffline(),
+		})
+	})
+
+	routesMux.HandleFunc("GET /api/events", func(w http.ResponseWriter, r *http.Request) {
+		httpx.ServeEvents(w, r, bus)
+	})
+
 	routesMux.HandleFunc("GET /api/recent", func(w http.ResponseWriter, r *http.Request) {
 		recent, err := db.ListRecent()
 		if err != nil {
@@ -125,6 +286,28 @@ func main() {
 		}
 	})
 
+	routesMux.HandleFunc("GET /api/library", func(w http.ResponseWriter, r *http.Request) {
+		entries, err := db.ListLibrary()
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "list library",
+			})
+			return
+		}
+		httpx.JSON(w, entries)
+	})
+
+	routesMux.HandleFunc("POST /api/library/scan", func(w http.ResponseWriter, r *http.Request) {
+		err := lib.Scan(r.Context())
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "scan library",
+			})
+		}
+	})
+
 	routesMux.HandleFunc("GET /api/meta/{type}/details/{id}", func(w http.ResponseWriter, r *http.Request) {
 		kind := r.PathValue("type")
 		if kind != "movie" && kind != "series" {
@@ -137,7 +320,7 @@ func main() {
 
 		id := r.PathValue("id")
 
-		res, err := metaAPI.Get(kind, id)
+		res, err := metaAPI.Get(r.Context(), kind, id)
 		if err != nil {
 			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
 				Err: err,
@@ -161,7 +344,7 @@ func main() {
 
 		query := r.PathValue("query")
 
-		res, err := metaAPI.Search(kind, query)
+		res, err := metaAPI.Search(r.Context(), kind, query)
 		if err != nil {
 			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
 				Err: err,
@@ -177,7 +360,7 @@ func main() {
 		imdbID := r.PathValue("imdbID") // TODO
 		kind := r.PathValue("type")     // TODO
 
-		streams, err := torrentSource.Find(kind, imdbID)
+		streams, err := torrentSource.Find(r.Context(), kind, imdbID)
 		if err != nil {
 			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
 				Err: err,
@@ -186,7 +369,510 @@ func main() {
 			return
 		}
 
-		httpx.JSON(w, streams)
+		// only streams found here can go through the proxy
+		for _, s := range streams {
+			if hints := s.BehaviorHints.ProxyHeaders; s.Kind() == source.KindURL && hints != nil {
+				streamProxy.Allow(s.URL, hints.Request, hints.Response)
+			}
+		}
+
+		streams = lib.PreferLocal(streams)
+		httpx.JSON(w, prober.Annotate(streams))
+	})
+
+	routesMux.HandleFunc("GET /api/local/{id}/stream", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err:    err,
+				Msg:    "invalid id",
+				Status: http.StatusBadRequest,
+			})
+			return
+		}
+
+		entry, err := db.GetLibraryEntry(id)
+		if errors.Is(err, errorsx.NotFound) {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Msg:    "library entry not found",
+				Status: http.StatusNotFound,
+			})
+			return
+		}
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "find library entry",
+			})
+			return
+		}
+
+		f, err := os.Open(entry.Path)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err:    err,
+				Msg:    "open library file",
+				Status: http.StatusNotFound,
+			})
+			return
+		}
+		defer f.Close()
+
+		stat, err := f.Stat()
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "stat library file",
+			})
+			return
+		}
+
+		w.Header().Set("Content-Type", httpx.VideoContentType(entry.Path))
+		http.ServeContent(w, r, stat.Name(), stat.ModTime(), f)
+	})
+
+	// proxiedURL is the URL of a url stream, going through the proxy when
+	// extra headers are needed to play it.
+	proxiedURL := func(stream source.Stream) (string, error) {
+		// the player command line is split on spaces, so only pass on
+		// well formed URLs
+		u, err := url.Parse(stream.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return "", fmt.Errorf("%w stream url %q", errorsx.Invalid, stream.URL)
+		}
+
+		hints := stream.BehaviorHints.ProxyHeaders
+		if hints == nil {
+			return u.String(), nil
+		}
+
+		id, err := streamProxy.Register(u.String())
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("%s/api/proxy/%s", baseURL, id), nil
+	}
+
+	routesMux.HandleFunc("POST /api/proxy", func(w http.ResponseWriter, r *http.Request) {
+		stream := source.Stream{}
+		err := json.NewDecoder(r.Body).Decode(&stream)
+		if err != nil || stream.URL == "" {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err:    err,
+				Msg:    "invalid stream",
+				Status: http.StatusBadRequest,
+			})
+			return
+		}
+
+		streamURL, err := proxiedURL(stream)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "proxy stream",
+			})
+			return
+		}
+
+		httpx.JSON(w, map[string]string{
+			"url": streamURL,
+		})
+	})
+
+	routesMux.Handle("GET /api/proxy/{id}", streamProxy)
+
+	routesMux.HandleFunc("POST /api/player", func(w http.ResponseWriter, r *http.Request) {
+		stream := source.Stream{}
+		err := json.NewDecoder(r.Body).Decode(&stream)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err:    err,
+				Msg:    "invalid stream",
+				Status: http.StatusBadRequest,
+			})
+			return
+		}
+
+		if e, ok := lib.LocalCopy(stream.InfoHash, stream.FileIdx); stream.InfoHash != "" && ok {
+			stream.LibraryID = e.ID
+		}
+
+		var streamURL string
+
+		switch {
+		case stream.LibraryID != 0:
+			streamURL = fmt.Sprintf("%s/api/local/%d/stream", baseURL, stream.LibraryID)
+
+		case stream.Kind() == source.KindTorrent && r.URL.Query().Get("id") != "":
+			q := r.URL.Query()
+			session, err := sessions.Start(r.Context(), q.Get("type"), q.Get("id"), stream)
+			if err != nil {
+				httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+					Err: err,
+					Msg: "start session",
+				})
+				return
+			}
+			streamURL = fmt.Sprintf("%s/api/sessions/%s/stream", baseURL, session.ID)
+
+		case stream.Kind() == source.KindTorrent:
+			err = torrentService.AddTrackers(stream.InfoHash, stream.Trackers())
+			if err != nil {
+				httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+					Err: err,
+					Msg: "invalid stream",
+				})
+				return
+			}
+			streamURL = fmt.Sprintf("%s/api/torrent/%s/%d/stream", baseURL, stream.InfoHash, stream.FileIdx)
+
+		case stream.Kind() == source.KindURL:
+			streamURL, err = proxiedURL(stream)
+			if err != nil {
+				httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+					Err: err,
+					Msg: "invalid stream",
+				})
+				return
+			}
+
+		case stream.Kind() == source.KindYouTube:
+			streamURL = "https://www.youtube.com/watch?v=" + url.QueryEscape(stream.YtID)
+
+		default:
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Msg:    "stream can't be opened in the player",
+				Status: http.StatusBadRequest,
+			})
+			return
+		}
+
+		params := player.Params{URL: streamURL}
+		if stream.LibraryID == 0 {
+			q := r.URL.Query()
+			params.Subs = playerSubs(r.Context(), torrentService, opensubtitles, cfg.SubLangs, q.Get("type"), q.Get("id"), stream)
+		}
+
+		err = videoPlayer.Launch(params)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "launch player",
+			})
+		}
+	})
+
+	routesMux.HandleFunc("POST /api/sessions", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Type   string        `json:"type"`
+			ID     string        `json:"id"`
+			Stream source.Stream `json:"stream"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err:    err,
+				Msg:    "invalid session",
+				Status: http.StatusBadRequest,
+			})
+			return
+		}
+
+		session, err := sessions.Start(r.Context(), req.Type, req.ID, req.Stream)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "start session",
+			})
+			return
+		}
+
+		httpx.JSON(w, session)
+	})
+
+	routesMux.HandleFunc("GET /api/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		session, err := sessions.Get(r.PathValue("id"))
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "get session",
+			})
+			return
+		}
+
+		httpx.JSON(w, session)
+	})
+
+	routesMux.HandleFunc("GET /api/sessions/{id}/stream", func(w http.ResponseWriter, r *http.Request) {
+		err := sessions.StreamHTTP(w, r, r.PathValue("id"))
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "stream session",
+			})
+		}
+	})
+
+	routesMux.HandleFunc("GET /api/torrent/client", func(w http.ResponseWriter, r *http.Request) {
+		httpx.JSON(w, torrentService.Status())
+	})
+
+	routesMux.HandleFunc("GET /api/torrent/bandwidth", func(w http.ResponseWriter, r *http.Request) {
+		httpx.JSON(w, torrentService.Bandwidth())
+	})
+
+	routesMux.HandleFunc("PUT /api/torrent/bandwidth", func(w http.ResponseWriter, r *http.Request) {
+		var bandwidth config.BandwidthConfig
+		err := json.NewDecoder(r.Body).Decode(&bandwidth)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err:    err,
+				Msg:    "invalid bandwidth config",
+				Status: http.StatusBadRequest,
+			})
+			return
+		}
+
+		err = torrentService.SetBandwidth(bandwidth)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "set bandwidth",
+			})
+			return
+		}
+
+		cfgMu.Lock()
+		fileCfg.Torrent.Bandwidth = bandwidth
+		err = config.Save(cfgPath, fileCfg)
+		cfgMu.Unlock()
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "save config",
+			})
+			return
+		}
+
+		httpx.JSON(w, torrentService.Bandwidth())
+	})
+
+	routesMux.HandleFunc("GET /api/torrent/seeding", func(w http.ResponseWriter, r *http.Request) {
+		httpx.JSON(w, torrentService.GlobalSeeding())
+	})
+
+	routesMux.HandleFunc("PUT /api/torrent/seeding", func(w http.ResponseWriter, r *http.Request) {
+		var policy config.SeedingPolicy
+		err := json.NewDecoder(r.Body).Decode(&policy)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err:    err,
+				Msg:    "invalid seeding policy",
+				Status: http.StatusBadRequest,
+			})
+			return
+		}
+
+		err = torrentService.SetGlobalSeeding(policy)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "set seeding policy",
+			})
+			return
+		}
+
+		cfgMu.Lock()
+		fileCfg.Torrent.Seeding = policy
+		err = config.Save(cfgPath, fileCfg)
+		cfgMu.Unlock()
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "save config",
+			})
+			return
+		}
+
+		httpx.JSON(w, policy)
+	})
+
+	routesMux.HandleFunc("GET /api/torrents", func(w http.ResponseWriter, r *http.Request) {
+		httpx.JSON(w, torrentService.Torrents())
+	})
+
+	routesMux.HandleFunc("DELETE /api/torrents/{infoHash}", func(w http.ResponseWriter, r *http.Request) {
+		infoHash := r.PathValue("infoHash")
+
+		deleteData := false
+		if v := r.URL.Query().Get("deleteData"); v != "" {
+			var err error
+			deleteData, err = strconv.ParseBool(v)
+			if err != nil {
+				httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+					Err:    err,
+					Msg:    "invalid deleteData",
+					Status: http.StatusBadRequest,
+				})
+				return
+			}
+		}
+
+		var err error
+		if deleteData {
+			err = torrentService.Delete(r.Context(), infoHash)
+		} else {
+			err = torrentService.Drop(r.Context(), infoHash)
+		}
+
+		// torrents that aren't loaded get a 404, since their data can't be
+		// found without the metadata, but their rows are deleted still
+		if err == nil || errors.Is(err, errorsx.NotFound) {
+			// or they would be resumed on the next start
+			dbErr := db.DeleteTorrentDownloads(infoHash)
+			if dbErr == nil && deleteData {
+				dbErr = db.DeleteSeedingState(infoHash)
+			}
+			err = errors.Join(err, dbErr)
+		}
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "delete torrent",
+			})
+		}
+	})
+
+	routesMux.HandleFunc("PUT /api/torrents/{infoHash}/seeding", func(w http.ResponseWriter, r *http.Request) {
+		ih, err := torrent.ParseInfoHash(r.PathValue("infoHash"))
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+			})
+			return
+		}
+
+		var policy config.SeedingPolicy
+		err = json.NewDecoder(r.Body).Decode(&policy)
+		if err == nil {
+			err = torrent.ValidateSeeding(policy)
+		}
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err:    err,
+				Msg:    "invalid seeding policy",
+				Status: http.StatusBadRequest,
+			})
+			return
+		}
+
+		err = db.SaveSeedingPolicy(ih.HexString(), policy)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "save seeding policy",
+			})
+			return
+		}
+
+		httpx.JSON(w, policy)
+	})
+
+	routesMux.HandleFunc("DELETE /api/torrents/{infoHash}/seeding", func(w http.ResponseWriter, r *http.Request) {
+		ih, err := torrent.ParseInfoHash(r.PathValue("infoHash"))
+		if err == nil {
+			err = db.DeleteSeedingPolicy(ih.HexString())
+		}
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "reset seeding policy",
+			})
+		}
+	})
+
+	routesMux.HandleFunc("POST /api/torrent", func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseMultipartForm(torrent.MaxTorrentFileSize)
+		if errors.Is(err, http.ErrNotMultipart) {
+			err = r.ParseForm()
+		}
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err:    err,
+				Msg:    "invalid form",
+				Status: http.StatusBadRequest,
+			})
+			return
+		}
+
+		var infoHash string
+
+		if f, _, ferr := r.FormFile("torrent"); ferr == nil {
+			defer f.Close()
+			infoHash, err = torrentService.AddTorrentFile(r.Context(), f)
+		} else if magnet := r.FormValue("magnet"); magnet != "" {
+			infoHash, err = torrentService.AddMagnet(r.Context(), magnet)
+		} else {
+			err = fmt.Errorf("%w form: a torrent file or magnet is required", errorsx.Invalid)
+		}
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "add torrent",
+			})
+			return
+		}
+
+		files, err := torrentService.Files(r.Context(), infoHash)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "list torrent files",
+			})
+			return
+		}
+
+		res := map[string]any{
+			"infoHash": infoHash,
+			"files":    files,
+		}
+
+		imdbID := r.FormValue("imdbId")
+		if imdbID == "" {
+			httpx.JSON(w, res)
+			return
+		}
+
+		stream, err := manualStream(r, imdbID, infoHash, files)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "invalid association",
+			})
+			return
+		}
+
+		stream.Trackers, err = torrentService.Trackers(infoHash)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "list trackers",
+			})
+			return
+		}
+
+		stream.ID, err = db.SaveManualStream(stream)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "save stream",
+			})
+			return
+		}
+
+		res["stream"] = stream
+		httpx.JSON(w, res)
 	})
 
 	routesMux.HandleFunc("GET /api/torrent/{infoHash}/{fileIdx}/stream", func(w http.ResponseWriter, r *http.Request) {
@@ -200,10 +886,16 @@ func main() {
 			})
 			return
 		}
-		torrentService.StreamFileHTTP(w, r, infoHash, fileIdx)
+		err = torrentService.StreamFileHTTP(w, r, infoHash, fileIdx)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "stream file",
+			})
+		}
 	})
 
-	routesMux.HandleFunc("GET /api/torrent/{infoHash}/{fileIdx}/download", func(w http.ResponseWriter, r *http.Request) {
+	routesMux.HandleFunc("POST /api/torrent/{infoHash}/{fileIdx}/download", func(w http.ResponseWriter, r *http.Request) {
 		infoHash := r.PathValue("infoHash")
 		fileIdx, err := strconv.Atoi(r.PathValue("fileIdx"))
 		if err != nil {
@@ -214,7 +906,13 @@ func main() {
 			})
 			return
 		}
-		torrentService.DownloadFile(infoHash, fileIdx)
+		err = torrentService.DownloadFile(r.Context(), infoHash, fileIdx)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "download file",
+			})
+		}
 	})
 
 	routesMux.HandleFunc("GET /api/torrent/{infoHash}/{fileIdx}/stat", func(w http.ResponseWriter, r *http.Request) {
@@ -229,14 +927,40 @@ func main() {
 			return
 		}
 
-		stat := torrentService.Stat(infoHash, fileIdx)
+		stat, err := torrentService.Stat(r.Context(), infoHash, fileIdx)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "get torrent stats",
+			})
+			return
+		}
 
 		httpx.JSON(w, stat)
 	})
 
-	routesMux.HandleFunc("GET /api/torrent/{infoHash}/drop", func(w http.ResponseWriter, r *http.Request) {
+	routesMux.HandleFunc("GET /api/torrent/{infoHash}/{fileIdx}/buffer", func(w http.ResponseWriter, r *http.Request) {
 		infoHash := r.PathValue("infoHash")
-		torrentService.Drop(infoHash)
+		fileIdx, err := strconv.Atoi(r.PathValue("fileIdx"))
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err:    err,
+				Msg:    "invalid fileIdx",
+				Status: http.StatusBadRequest,
+			})
+			return
+		}
+
+		buf, err := torrentService.Buffer(r.Context(), infoHash, fileIdx)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err: err,
+				Msg: "get torrent buffer",
+			})
+			return
+		}
+
+		httpx.JSON(w, buf)
 	})
 
 	routesMux.HandleFunc("GET /api/torrent/{infoHash}/{fileIdx}/hash", func(w http.ResponseWriter, r *http.Request) {
@@ -251,7 +975,7 @@ func main() {
 			return
 		}
 
-		hash, err := torrentService.FileHash(infoHash, fileIdx)
+		hash, err := torrentService.FileHash(r.Context(), infoHash, fileIdx)
 		if err != nil {
 			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
 				Err: err,
@@ -267,12 +991,101 @@ func main() {
 		httpx.JSON(w, res)
 	})
 
+	routesMux.HandleFunc("GET /api/torrent/{infoHash}/{fileIdx}/probe", func(w http.ResponseWriter, r *http.Request) {
+		infoHash := r.PathValue("infoHash")
+		fileIdx, err := strconv.Atoi(r.PathValue("fileIdx"))
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err:    err,
+				Msg:    "invalid fileIdx",
+				Status: http.StatusBadRequest,
+			})
+			return
+		}
+
+		info, err := torrentService.Probe(r.Context(), infoHash, fileIdx)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err:    err,
+				Msg:    "probe file",
+				Status: mediaErrorStatus(err),
+			})
+			return
+		}
+
+		httpx.JSON(w, info)
+	})
+
+	routesMux.HandleFunc("GET /api/torrent/{infoHash}/{fileIdx}/tracks", func(w http.ResponseWriter, r *http.Request) {
+		infoHash := r.PathValue("infoHash")
+		fileIdx, err := strconv.Atoi(r.PathValue("fileIdx"))
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err:    err,
+				Msg:    "invalid fileIdx",
+				Status: http.StatusBadRequest,
+			})
+			return
+		}
+
+		tracks, err := torrentService.Tracks(r.Context(), infoHash, fileIdx)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err:    err,
+				Msg:    "list tracks",
+				Status: mediaErrorStatus(err),
+			})
+			return
+		}
+
+		httpx.JSON(w, tracks)
+	})
+
+	routesMux.HandleFunc("GET /api/torrent/{infoHash}/{fileIdx}/tracks/{track}/vtt", func(w http.ResponseWriter, r *http.Request) {
+		infoHash := r.PathValue("infoHash")
+		fileIdx, err := strconv.Atoi(r.PathValue("fileIdx"))
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err:    err,
+				Msg:    "invalid fileIdx",
+				Status: http.StatusBadRequest,
+			})
+			return
+		}
+
+		track, err := strconv.ParseUint(r.PathValue("track"), 10, 64)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err:    err,
+				Msg:    "invalid track",
+				Status: http.StatusBadRequest,
+			})
+			return
+		}
+
+		cues, err := torrentService.Subtitles(r.Context(), infoHash, fileIdx, track)
+		if err != nil {
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Err:    err,
+				Msg:    "extract subtitles",
+				Status: mediaErrorStatus(err),
+			})
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+		err = mkv.WriteVTT(w, cues)
+		if err != nil {
+			slog.Error("write subtitles", "err", err)
+		}
+	})
+
 	routesMux.HandleFunc("GET /api/opensubs/{type}/{imdbID}/{fileHash}", func(w http.ResponseWriter, r *http.Request) {
 		kind := r.PathValue("type")
 		imdbID := r.PathValue("imdbID")
 		fileHash := r.PathValue("fileHash")
 
-		subs, err := opensubtitles.Search(kind, imdbID, fileHash)
+		subs, err := opensubtitles.Search(r.Context(), kind, imdbID, fileHash)
 		if err != nil {
 			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
 				Err: err,
@@ -285,12 +1098,136 @@ func main() {
 	})
 	//mux.HandleFunc("GET /api/opensubs/{id}", subsService.handleFindSubByID)
 
+	if !cfg.NoBrowser {
+		go func() {
+			time.Sleep(time.Second)
+			_ = exec.Command("xdg-open", baseURL).Run()
+		}()
+	}
+
+	srv := &http.Server{
+		Handler: mux,
+	}
+	// event streams never finish by themselves
+	srv.RegisterOnShutdown(bus.Close)
+
+	served := make(chan error, 1)
 	go func() {
-		time.Sleep(time.Second)
-		_ = exec.Command("xdg-open", baseURL).Run()
+		served <- srv.Serve(ln)
 	}()
 
-	slog.Info("starting anyflix at " + baseURL)
-	err = http.ListenAndServe(fmt.Sprintf("%s:%d", host, port), mux)
-	log.Panic(err)
+	slog.Info("starting anyflix at "+baseURL, "addr", ln.Addr().String())
+
+	failed := false
+	select {
+	case err := <-served:
+		slog.Error("failed to serve", "err", err)
+		failed = true
+	case <-ctx.Done():
+	}
+
+	// a second signal kills anyflix right away
+	stop()
+
+	err = shutdown(srv, videoPlayer, torrentService)
+	if err != nil || failed {
+		os.Exit(1)
+	}
+	slog.Info("shut down")
+}
+
+// manualStream reads the season, episode and fileIdx form values, which
+// default to 0, 0 and the largest file of the torrent.
+func manualStream(r *http.Request, imdbID, infoHash string, files []torrent.File) (db.ManualStream, error) {
+	m := db.ManualStream{
+		IMDBID:   imdbID,
+		InfoHash: infoHash,
+	}
+
+	ints := map[string]*int{
+		"season":  &m.Season,
+		"episode": &m.Episode,
+		"fileIdx": &m.FileIdx,
+	}
+
+	m.FileIdx = -1
+	for name, dst := range ints {
+		v := r.FormValue(name)
+		if v == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return m, fmt.Errorf("%w %s %q", errorsx.Invalid, name, v)
+		}
+		*dst = n
+	}
+
+	if m.FileIdx == -1 {
+		m.FileIdx = 0
+		for _, f := range files {
+			if f.Size > files[m.FileIdx].Size {
+				m.FileIdx = f.Index
+			}
+		}
+	}
+
+	if m.FileIdx >= len(files) {
+		return m, fmt.Errorf("file %d: %w", m.FileIdx, errorsx.NotFound)
+	}
+
+	m.Name = files[m.FileIdx].Path
+	m.Size = files[m.FileIdx].Size
+	return m, nil
+}
+
+// recoverPanics turns panics into 500 responses carrying a request id, which
+// is also logged with the stack trace.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := make([]byte, 8)
+		rand.Read(id)
+		requestID := hex.EncodeToString(id)
+		w.Header().Set("X-Request-Id", requestID)
+
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+
+			// used by net/http to abort a response on purpose
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			slog.Error("panic handling request",
+				"requestId", requestID,
+				"method", r.Method,
+				"path", r.URL.Path,
+				"panic", rec,
+				"stack", string(debug.Stack()))
+
+			httpx.ErrorJSON(w, httpx.ErrorJSONParams{
+				Msg:    "internal error, request id " + requestID,
+				Status: http.StatusInternalServerError,
+			})
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func mediaErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, mkv.ErrNotMatroska), errors.Is(err, mkv.ErrUnsupportedCodec),
+		errors.Is(err, probe.ErrUnknownContainer):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, mkv.ErrTrackNotFound):
+		return http.StatusNotFound
+	default:
+		// let httpx.ErrorJSON pick it from the error
+		return 0
+	}
 }
@@ -1,21 +1,33 @@
 package meta
 
 import (
-	"encoding/json"
+	"context"
 	"log/slog"
-	"net/http"
 	"slices"
 
 	"github.com/igorcafe/anyflix/filler"
+	"github.com/igorcafe/anyflix/httpx"
 )
 
 type API struct {
 	BaseURL string
+	HTTP    *httpx.Client
+
+	// Cache, when set, keeps every title fetched by Get and answers for the
+	// API when it can't be reached.
+	Cache Cache
+}
+
+// Cache stores metadata for offline use.
+type Cache interface {
+	GetMeta(kind, id string) (Meta, error)
+	SaveMeta(kind string, m Meta) error
+	SearchMeta(kind, query string) ([]Meta, error)
 }
 
 func DefaultAPI() API {
 	return API{
-		"https://v3-cinemeta.strem.io",
+		BaseURL: "https://v3-cinemeta.strem.io",
 	}
 }
 
@@ -52,24 +64,36 @@ type Video struct {
 	Type   string `json:"type"`
 }
 
-func (s API) Get(kind, id string) (Meta, error) {
-	var res getMetaResponse
-	url := s.BaseURL + "/meta/" + kind + "/" + id + ".json"
+func (s API) Get(ctx context.Context, kind, id string) (Meta, error) {
+	m, err := s.get(ctx, kind, id)
+	if s.Cache == nil {
+		return m, err
+	}
 
-	slog.Debug("meta.API.Get", "kind", kind, "id", id, "url", url)
-	resp, err := http.Get(url)
 	if err != nil {
-		slog.Error("search meta",
-			"url", url,
-			"err", err)
-		return Meta{}, err
+		cached, cacheErr := s.Cache.GetMeta(kind, id)
+		if cacheErr != nil {
+			return Meta{}, err
+		}
+
+		slog.Warn("using cached meta", "kind", kind, "id", id, "err", err)
+		return cached, nil
+	}
+
+	err = s.Cache.SaveMeta(kind, m)
+	if err != nil {
+		slog.Error("failed to cache meta", "kind", kind, "id", id, "err", err)
 	}
-	defer resp.Body.Close()
 
-	err = json.NewDecoder(resp.Body).Decode(&res)
+	return m, nil
+}
+
+func (s API) get(ctx context.Context, kind, id string) (Meta, error) {
+	var res getMetaResponse
+	url := s.BaseURL + "/meta/" + kind + "/" + id + ".json"
+
+	err := s.HTTP.GetJSON(ctx, url, &res)
 	if err != nil {
-		slog.Error("parse meta json",
-			"err", err)
 		return Meta{}, err
 	}
 
@@ -78,7 +102,7 @@ func (s API) Get(kind, id string) (Meta, error) {
 	})
 
 	if kind == "series" {
-		fillers, err := filler.SearchShow(res.Meta.Name)
+		fillers, err := filler.SearchShow(ctx, s.HTTP, res.Meta.Name)
 		if err != nil {
 			slog.Error("search fillers", "err", err)
 		} else {
@@ -96,23 +120,27 @@ func (s API) Get(kind, id string) (Meta, error) {
 	return res.Meta, nil
 }
 
-func (s API) Search(kind string, query string) ([]Meta, error) {
-	var res searchMetaResponse
-	url := s.BaseURL + "/catalog/" + kind + "/top/search=" + query + ".json"
+func (s API) Search(ctx context.Context, kind string, query string) ([]Meta, error) {
+	metas, err := s.search(ctx, kind, query)
+	if err == nil || s.Cache == nil {
+		return metas, err
+	}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		slog.Error("search meta",
-			"url", url,
-			"err", err)
+	cached, cacheErr := s.Cache.SearchMeta(kind, query)
+	if cacheErr != nil || len(cached) == 0 {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	err = json.NewDecoder(resp.Body).Decode(&res)
+	slog.Warn("using cached search results", "kind", kind, "query", query, "err", err)
+	return cached, nil
+}
+
+func (s API) search(ctx context.Context, kind string, query string) ([]Meta, error) {
+	var res searchMetaResponse
+	url := s.BaseURL + "/catalog/" + kind + "/top/search=" + query + ".json"
+
+	err := s.HTTP.GetJSON(ctx, url, &res)
 	if err != nil {
-		slog.Error("parse meta json",
-			"err", err)
 		return nil, err
 	}
 
@@ -0,0 +1,227 @@
+package mkv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// maxElementSize caps how much of a single element is loaded into memory.
+const maxElementSize = 16 * 1024 * 1024
+
+var errInvalidVint = errors.New("invalid variable size integer")
+
+// element is an EBML element header. pos is the offset of the header and
+// size is -1 when unknown.
+type element struct {
+	id      uint32
+	pos     int64
+	size    int64
+	dataPos int64
+}
+
+func (el element) end() int64 {
+	if el.size < 0 {
+		return -1
+	}
+	return el.dataPos + el.size
+}
+
+// reader keeps track of its own position so that consecutive reads don't need
+// an extra Seek call, which is relatively expensive over a torrent reader.
+type reader struct {
+	r   io.ReadSeeker
+	pos int64
+}
+
+func (r *reader) seek(pos int64) error {
+	if pos == r.pos {
+		return nil
+	}
+
+	_, err := r.r.Seek(pos, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	r.pos = pos
+	return nil
+}
+
+func (r *reader) readFull(b []byte) error {
+	n, err := io.ReadFull(r.r, b)
+	r.pos += int64(n)
+	return err
+}
+
+// readVint reads a variable size integer, returning its value with the
+// length marker kept (as used by IDs) and removed (as used by sizes).
+func (r *reader) readVint() (raw uint64, value uint64, length int, err error) {
+	var b [8]byte
+	err = r.readFull(b[:1])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	length = 1
+	for mask := byte(0x80); b[0]&mask == 0; mask >>= 1 {
+		length++
+		if mask == 1 {
+			return 0, 0, 0, errInvalidVint
+		}
+	}
+
+	err = r.readFull(b[1:length])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	for i := range length {
+		raw = raw<<8 | uint64(b[i])
+	}
+
+	value = raw &^ (1 << (7 * length))
+	return raw, value, length, nil
+}
+
+// next reads the element header at the current position.
+func (r *reader) next() (element, error) {
+	pos := r.pos
+
+	raw, _, length, err := r.readVint()
+	if err != nil {
+		return element{}, err
+	}
+	if length > 4 {
+		return element{}, fmt.Errorf("invalid element id %x", raw)
+	}
+
+	_, size, length, err := r.readVint()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return element{}, err
+	}
+
+	el := element{
+		id:      uint32(raw),
+		pos:     pos,
+		size:    int64(size),
+		dataPos: r.pos,
+	}
+
+	if size == 1<<(7*length)-1 {
+		el.size = -1
+	}
+
+	return el, nil
+}
+
+// each calls fn for every child of parent. fn may read the child data, the
+// reader is positioned at the next sibling afterwards either way.
+func (r *reader) each(parent element, fn func(el element) error) error {
+	end := parent.end()
+	pos := parent.dataPos
+
+	for end < 0 || pos < end {
+		err := r.seek(pos)
+		if err != nil {
+			return err
+		}
+
+		el, err := r.next()
+		if end < 0 && err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = fn(el)
+		if errors.Is(err, errStop) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if el.size < 0 {
+			return fmt.Errorf("element %x has unknown size", el.id)
+		}
+		pos = el.end()
+	}
+
+	return nil
+}
+
+// errStop can be returned from an each callback to stop the iteration.
+var errStop = errors.New("stop")
+
+func (r *reader) readBytes(el element) ([]byte, error) {
+	if el.size < 0 || el.size > maxElementSize {
+		return nil, fmt.Errorf("element %x too large: %d bytes", el.id, el.size)
+	}
+
+	err := r.seek(el.dataPos)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, el.size)
+	err = r.readFull(b)
+	return b, err
+}
+
+func (r *reader) readUint(el element) (uint64, error) {
+	if el.size > 8 {
+		return 0, fmt.Errorf("invalid uint element %x size %d", el.id, el.size)
+	}
+
+	b, err := r.readBytes(el)
+	if err != nil {
+		return 0, err
+	}
+
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+func (r *reader) readFloat(el element) (float64, error) {
+	b, err := r.readBytes(el)
+	if err != nil {
+		return 0, err
+	}
+
+	switch len(b) {
+	case 0:
+		return 0, nil
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	default:
+		return 0, fmt.Errorf("invalid float element %x size %d", el.id, el.size)
+	}
+}
+
+func (r *reader) readString(el element) (string, error) {
+	b, err := r.readBytes(el)
+	if err != nil {
+		return "", err
+	}
+
+	// strings may be zero padded
+	for i, c := range b {
+		if c == 0 {
+			b = b[:i]
+			break
+		}
+	}
+	return string(b), nil
+}
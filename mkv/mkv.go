@@ -0,0 +1,541 @@
+// Package mkv reads Matroska/WebM files without loading them into memory.
+//
+// Only the header elements (Info, Tracks, Cues) are read when opening a file,
+// everything else is skipped by seeking, which makes it cheap to use over a
+// torrent reader.
+package mkv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	idEBML            = 0x1A45DFA3
+	idDocType         = 0x4282
+	idSegment         = 0x18538067
+	idSeekHead        = 0x114D9B74
+	idSeek            = 0x4DBB
+	idSeekID          = 0x53AB
+	idSeekPosition    = 0x53AC
+	idInfo            = 0x1549A966
+	idTimecodeScale   = 0x2AD7B1
+	idDuration        = 0x4489
+	idTracks          = 0x1654AE6B
+	idTrackEntry      = 0xAE
+	idTrackNumber     = 0xD7
+	idTrackType       = 0x83
+	idFlagDefault     = 0x88
+	idFlagForced      = 0x55AA
+	idName            = 0x536E
+	idLanguage        = 0x22B59C
+	idLanguageBCP47   = 0x22B59D
+	idCodecID         = 0x86
+	idVideo           = 0xE0
+	idPixelWidth      = 0xB0
+	idPixelHeight     = 0xBA
+	idColour          = 0x55B0
+	idTransferChars   = 0x55BA
+	idAudio           = 0xE1
+	idSamplingFreq    = 0xB5
+	idChannels        = 0x9F
+	idBlockAddMapping = 0x41E4
+	idBlockAddIDType  = 0x41E7
+	idContentEncs     = 0x6D80
+	idContentEnc      = 0x6240
+	idContentComp     = 0x5034
+	idContentCompAlgo = 0x4254
+	idContentCompSets = 0x4255
+	idCues            = 0x1C53BB6B
+	idCuePoint        = 0xBB
+	idCueTime         = 0xB3
+	idCueTrackPos     = 0xB7
+	idCueTrack        = 0xF7
+	idCueClusterPos   = 0xF1
+	idCueRelativePos  = 0xF0
+	idCluster         = 0x1F43B675
+	idTimecode        = 0xE7
+	idSimpleBlock     = 0xA3
+	idBlockGroup      = 0xA0
+	idBlock           = 0xA1
+	idBlockDuration   = 0x9B
+)
+
+var (
+	ErrNotMatroska      = errors.New("not a matroska file")
+	ErrTrackNotFound    = errors.New("track not found")
+	ErrUnsupportedCodec = errors.New("unsupported codec")
+)
+
+const (
+	TrackVideo    = "video"
+	TrackAudio    = "audio"
+	TrackSubtitle = "subtitle"
+	TrackOther    = "other"
+)
+
+type Track struct {
+	Number   uint64 `json:"number"`
+	Type     string `json:"type"`
+	Codec    string `json:"codec"`
+	Language string `json:"language"`
+	Name     string `json:"name,omitempty"`
+	Default  bool   `json:"default"`
+	Forced   bool   `json:"forced"`
+
+	Video *Video `json:"video,omitempty"`
+	Audio *Audio `json:"audio,omitempty"`
+
+	compression *compression
+}
+
+type Video struct {
+	Width  uint64 `json:"width"`
+	Height uint64 `json:"height"`
+	// TransferCharacteristics follows ITU-T H.273: 16 is PQ (HDR10), 18 is HLG.
+	TransferCharacteristics uint64 `json:"transferCharacteristics,omitempty"`
+	DolbyVision             bool   `json:"dolbyVision,omitempty"`
+}
+
+type Audio struct {
+	Channels   uint64  `json:"channels"`
+	SampleRate float64 `json:"sampleRate"`
+}
+
+// IsText reports whether the track is a subtitle track that can be converted
+// to WebVTT.
+func (t Track) IsText() bool {
+	switch t.Codec {
+	case "S_TEXT/UTF8", "S_TEXT/ASS", "S_TEXT/SSA", "S_TEXT/WEBVTT":
+		return true
+	}
+	return false
+}
+
+type compression struct {
+	algo     uint64
+	settings []byte
+}
+
+type cuePoint struct {
+	track    uint64
+	cluster  int64
+	relative int64
+}
+
+// File is an opened Matroska file.
+type File struct {
+	r reader
+
+	segment      element
+	firstCluster int64
+	cuesPos      int64
+
+	// DocType is either "matroska" or "webm".
+	DocType string
+	// TimecodeScale is the number of nanoseconds per timecode unit.
+	TimecodeScale uint64
+	// Duration of the segment, in timecode units.
+	Duration float64
+	Tracks   []Track
+
+	cues []cuePoint
+}
+
+// Open parses the headers of the Matroska file in r.
+func Open(r io.ReadSeeker) (*File, error) {
+	f := &File{
+		r:             reader{r: r},
+		TimecodeScale: 1000000,
+	}
+
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := f.r.next()
+	if err != nil || header.id != idEBML {
+		return nil, ErrNotMatroska
+	}
+
+	err = f.r.each(header, func(el element) error {
+		var err error
+		if el.id == idDocType {
+			f.DocType, err = f.r.readString(el)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if f.DocType != "matroska" && f.DocType != "webm" {
+		return nil, ErrNotMatroska
+	}
+
+	err = f.r.seek(header.end())
+	if err != nil {
+		return nil, err
+	}
+
+	f.segment, err = f.r.next()
+	if err != nil {
+		return nil, err
+	}
+	if f.segment.id != idSegment {
+		return nil, fmt.Errorf("%w: missing segment", ErrNotMatroska)
+	}
+
+	err = f.readHeaders()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// readHeaders reads the top level elements up to the first cluster, then
+// follows the seek head for anything that lives after the clusters.
+func (f *File) readHeaders() error {
+	var infoPos, tracksPos int64
+	gotInfo, gotTracks := false, false
+
+	err := f.r.each(f.segment, func(el element) error {
+		var err error
+
+		switch el.id {
+		case idSeekHead:
+			err = f.readSeekHead(el, &infoPos, &tracksPos)
+		case idInfo:
+			gotInfo = true
+			err = f.readInfo(el)
+		case idTracks:
+			gotTracks = true
+			err = f.readTracks(el)
+		case idCues:
+			f.cuesPos = el.pos
+		case idCluster:
+			f.firstCluster = el.pos
+			return errStop
+		}
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if !gotInfo && infoPos > 0 {
+		err = f.readAt(infoPos, idInfo, f.readInfo)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !gotTracks && tracksPos > 0 {
+		err = f.readAt(tracksPos, idTracks, f.readTracks)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *File) readAt(pos int64, id uint32, fn func(element) error) error {
+	err := f.r.seek(pos)
+	if err != nil {
+		return err
+	}
+
+	el, err := f.r.next()
+	if err != nil {
+		return err
+	}
+	if el.id != id {
+		return fmt.Errorf("expected element %x at %d, got %x", id, pos, el.id)
+	}
+
+	return fn(el)
+}
+
+func (f *File) readSeekHead(seekHead element, infoPos, tracksPos *int64) error {
+	return f.r.each(seekHead, func(seek element) error {
+		if seek.id != idSeek {
+			return nil
+		}
+
+		var id, pos uint64
+		err := f.r.each(seek, func(el element) error {
+			var err error
+			switch el.id {
+			case idSeekID:
+				id, err = f.r.readUint(el)
+			case idSeekPosition:
+				pos, err = f.r.readUint(el)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		abs := f.segment.dataPos + int64(pos)
+		switch id {
+		case idInfo:
+			*infoPos = abs
+		case idTracks:
+			*tracksPos = abs
+		case idCues:
+			f.cuesPos = abs
+		}
+
+		return nil
+	})
+}
+
+func (f *File) readInfo(info element) error {
+	return f.r.each(info, func(el element) error {
+		var err error
+		switch el.id {
+		case idTimecodeScale:
+			f.TimecodeScale, err = f.r.readUint(el)
+		case idDuration:
+			f.Duration, err = f.r.readFloat(el)
+		}
+		return err
+	})
+}
+
+func (f *File) readTracks(tracks element) error {
+	return f.r.each(tracks, func(entry element) error {
+		if entry.id != idTrackEntry {
+			return nil
+		}
+
+		track, err := f.readTrackEntry(entry)
+		if err != nil {
+			return err
+		}
+
+		f.Tracks = append(f.Tracks, track)
+		return nil
+	})
+}
+
+func (f *File) readTrackEntry(entry element) (Track, error) {
+	track := Track{
+		Language: "eng",
+		Default:  true,
+	}
+	bcp47 := ""
+
+	err := f.r.each(entry, func(el element) error {
+		var err error
+		var n uint64
+
+		switch el.id {
+		case idTrackNumber:
+			track.Number, err = f.r.readUint(el)
+		case idTrackType:
+			n, err = f.r.readUint(el)
+			track.Type = trackType(n)
+		case idFlagDefault:
+			n, err = f.r.readUint(el)
+			track.Default = n == 1
+		case idFlagForced:
+			n, err = f.r.readUint(el)
+			track.Forced = n == 1
+		case idName:
+			track.Name, err = f.r.readString(el)
+		case idLanguage:
+			track.Language, err = f.r.readString(el)
+		case idLanguageBCP47:
+			bcp47, err = f.r.readString(el)
+		case idCodecID:
+			track.Codec, err = f.r.readString(el)
+		case idContentEncs:
+			track.compression, err = f.readCompression(el)
+		case idVideo:
+			if track.Video == nil {
+				track.Video = &Video{}
+			}
+			err = f.readVideo(el, track.Video)
+		case idAudio:
+			track.Audio, err = f.readAudio(el)
+		case idBlockAddMapping:
+			if track.Video == nil {
+				track.Video = &Video{}
+			}
+			err = f.readBlockAddMapping(el, track.Video)
+		}
+
+		return err
+	})
+
+	if bcp47 != "" {
+		track.Language = bcp47
+	}
+
+	return track, err
+}
+
+func (f *File) readVideo(video element, v *Video) error {
+	return f.r.each(video, func(el element) error {
+		var err error
+
+		switch el.id {
+		case idPixelWidth:
+			v.Width, err = f.r.readUint(el)
+		case idPixelHeight:
+			v.Height, err = f.r.readUint(el)
+		case idColour:
+			err = f.r.each(el, func(el element) error {
+				var err error
+				if el.id == idTransferChars {
+					v.TransferCharacteristics, err = f.r.readUint(el)
+				}
+				return err
+			})
+		}
+
+		return err
+	})
+}
+
+func (f *File) readAudio(audio element) (*Audio, error) {
+	a := &Audio{
+		Channels:   1,
+		SampleRate: 8000,
+	}
+
+	err := f.r.each(audio, func(el element) error {
+		var err error
+
+		switch el.id {
+		case idChannels:
+			a.Channels, err = f.r.readUint(el)
+		case idSamplingFreq:
+			a.SampleRate, err = f.r.readFloat(el)
+		}
+
+		return err
+	})
+
+	return a, err
+}
+
+// readBlockAddMapping detects Dolby Vision configuration records.
+func (f *File) readBlockAddMapping(mapping element, v *Video) error {
+	return f.r.each(mapping, func(el element) error {
+		if el.id != idBlockAddIDType {
+			return nil
+		}
+
+		n, err := f.r.readUint(el)
+		if n == 0x64766343 || n == 0x64767643 { // dvcC, dvvC
+			v.DolbyVision = true
+		}
+		return err
+	})
+}
+
+func trackType(n uint64) string {
+	switch n {
+	case 1:
+		return TrackVideo
+	case 2:
+		return TrackAudio
+	case 0x11:
+		return TrackSubtitle
+	default:
+		return TrackOther
+	}
+}
+
+func (f *File) readCompression(encodings element) (*compression, error) {
+	var comp *compression
+
+	err := f.r.each(encodings, func(enc element) error {
+		if enc.id != idContentEnc {
+			return nil
+		}
+
+		return f.r.each(enc, func(el element) error {
+			if el.id != idContentComp {
+				return nil
+			}
+
+			comp = &compression{}
+			return f.r.each(el, func(el element) error {
+				var err error
+				switch el.id {
+				case idContentCompAlgo:
+					comp.algo, err = f.r.readUint(el)
+				case idContentCompSets:
+					comp.settings, err = f.r.readBytes(el)
+				}
+				return err
+			})
+		})
+	})
+
+	return comp, err
+}
+
+// Track returns the track with the given number.
+func (f *File) Track(number uint64) (Track, error) {
+	for _, track := range f.Tracks {
+		if track.Number == number {
+			return track, nil
+		}
+	}
+	return Track{}, ErrTrackNotFound
+}
+
+// loadCues reads the cue index, if the file has one.
+func (f *File) loadCues() error {
+	if f.cues != nil || f.cuesPos == 0 {
+		return nil
+	}
+
+	f.cues = []cuePoint{}
+
+	return f.readAt(f.cuesPos, idCues, func(cues element) error {
+		return f.r.each(cues, func(point element) error {
+			if point.id != idCuePoint {
+				return nil
+			}
+
+			return f.r.each(point, func(pos element) error {
+				if pos.id != idCueTrackPos {
+					return nil
+				}
+
+				cue := cuePoint{relative: -1}
+				err := f.r.each(pos, func(el element) error {
+					var err error
+					var n uint64
+
+					switch el.id {
+					case idCueTrack:
+						cue.track, err = f.r.readUint(el)
+					case idCueClusterPos:
+						n, err = f.r.readUint(el)
+						cue.cluster = f.segment.dataPos + int64(n)
+					case idCueRelativePos:
+						n, err = f.r.readUint(el)
+						cue.relative = int64(n)
+					}
+
+					return err
+				})
+
+				f.cues = append(f.cues, cue)
+				return err
+			})
+		})
+	})
+}
@@ -0,0 +1,176 @@
+package mkv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// el encodes an EBML element with an 8 byte size, so its length doesn't
+// depend on the payload values.
+func el(id uint32, payload ...[]byte) []byte {
+	data := bytes.Join(payload, nil)
+
+	var b []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if c := byte(id >> shift); c != 0 || len(b) > 0 {
+			b = append(b, c)
+		}
+	}
+
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(len(data)))
+	size[0] = 0x01
+
+	return append(append(b, size...), data...)
+}
+
+func u(id uint32, n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return el(id, b)
+}
+
+func str(id uint32, s string) []byte {
+	return el(id, []byte(s))
+}
+
+func simpleBlock(id uint32, track byte, timecode int16, data string) []byte {
+	header := []byte{0x80 | track, 0, 0, 0x80}
+	binary.BigEndian.PutUint16(header[1:3], uint16(timecode))
+	return el(id, header, []byte(data))
+}
+
+func testFile(withCues bool) []byte {
+	duration := make([]byte, 8)
+	binary.BigEndian.PutUint64(duration, math.Float64bits(9000))
+
+	info := el(idInfo, u(idTimecodeScale, 1000000), el(idDuration, duration))
+	tracks := el(idTracks,
+		el(idTrackEntry, u(idTrackNumber, 1), u(idTrackType, 1), str(idCodecID, "V_MPEG4/ISO/AVC")),
+		el(idTrackEntry, u(idTrackNumber, 2), u(idTrackType, 2), str(idCodecID, "A_AAC"), str(idLanguage, "jpn")),
+		el(idTrackEntry, u(idTrackNumber, 3), u(idTrackType, 0x11), str(idCodecID, "S_TEXT/UTF8"),
+			str(idLanguage, "por"), str(idName, "Portuguese"), u(idFlagForced, 1), u(idFlagDefault, 0)),
+		el(idTrackEntry, u(idTrackNumber, 4), u(idTrackType, 0x11), str(idCodecID, "S_TEXT/ASS")),
+	)
+
+	cluster1 := el(idCluster,
+		u(idTimecode, 1000),
+		simpleBlock(idSimpleBlock, 1, 0, "video"),
+		el(idBlockGroup, simpleBlock(idBlock, 3, 0, "Hello\r\n\r\nworld"), u(idBlockDuration, 500)),
+		el(idBlockGroup, simpleBlock(idBlock, 4, 200, `0,0,Default,,0,0,0,,{\i1}Hi\Nthere`), u(idBlockDuration, 1000)),
+	)
+	cluster2 := el(idCluster,
+		u(idTimecode, 5000),
+		simpleBlock(idSimpleBlock, 1, 0, "video"),
+		el(idBlockGroup, simpleBlock(idBlock, 3, 0, "Bye")),
+	)
+
+	// both subtitle blocks come right after the timecode and a video block
+	rel := int64(len(u(idTimecode, 0)) + len(simpleBlock(idSimpleBlock, 1, 0, "video")))
+
+	cues := func(c1, c2 int64) []byte {
+		return el(idCues,
+			el(idCuePoint, u(idCueTime, 1000),
+				el(idCueTrackPos, u(idCueTrack, 3), u(idCueClusterPos, uint64(c1)), u(idCueRelativePos, uint64(rel)))),
+			el(idCuePoint, u(idCueTime, 5000),
+				el(idCueTrackPos, u(idCueTrack, 3), u(idCueClusterPos, uint64(c2)), u(idCueRelativePos, uint64(rel)))),
+		)
+	}
+
+	children := [][]byte{info, tracks}
+	if withCues {
+		c1 := int64(len(info) + len(tracks) + len(cues(0, 0)))
+		c2 := c1 + int64(len(cluster1))
+		children = append(children, cues(c1, c2))
+	}
+	children = append(children, cluster1, cluster2)
+
+	header := el(idEBML, str(idDocType, "matroska"))
+	return append(header, el(idSegment, children...)...)
+}
+
+func TestOpen(t *testing.T) {
+	f, err := Open(bytes.NewReader(testFile(false)))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if f.Duration != 9000 {
+		t.Fatalf("expected duration 9000, got %v", f.Duration)
+	}
+
+	if len(f.Tracks) != 4 {
+		t.Fatalf("expected 4 tracks, got %d", len(f.Tracks))
+	}
+
+	sub := f.Tracks[2]
+	want := Track{Number: 3, Type: TrackSubtitle, Codec: "S_TEXT/UTF8", Language: "por", Name: "Portuguese", Forced: true}
+	if sub != want {
+		t.Fatalf("expected track %+v, got %+v", want, sub)
+	}
+
+	if f.Tracks[3].Language != "eng" || !f.Tracks[3].Default {
+		t.Fatalf("expected track defaults, got %+v", f.Tracks[3])
+	}
+}
+
+func TestOpenNotMatroska(t *testing.T) {
+	_, err := Open(strings.NewReader("definitely not a matroska file"))
+	if err != ErrNotMatroska {
+		t.Fatalf("expected ErrNotMatroska, got %v", err)
+	}
+}
+
+func TestSubtitles(t *testing.T) {
+	for _, withCues := range []bool{false, true} {
+		f, err := Open(bytes.NewReader(testFile(withCues)))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		cues, err := f.Subtitles(3)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		want := []Cue{
+			{Start: time.Second, End: 1500 * time.Millisecond, Text: "Hello\nworld"},
+			{Start: 5 * time.Second, End: 8 * time.Second, Text: "Bye"},
+		}
+		if len(cues) != len(want) {
+			t.Fatalf("withCues=%v: expected %d cues, got %+v", withCues, len(want), cues)
+		}
+		for i := range want {
+			if cues[i] != want[i] {
+				t.Fatalf("withCues=%v: expected cue %+v, got %+v", withCues, want[i], cues[i])
+			}
+		}
+	}
+}
+
+func TestSubtitlesASS(t *testing.T) {
+	f, err := Open(bytes.NewReader(testFile(false)))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	cues, err := f.Subtitles(4)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	err = WriteVTT(buf, cues)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "WEBVTT\n\n00:00:01.200 --> 00:00:02.200\nHi\nthere\n\n"
+	if buf.String() != want {
+		t.Fatalf("expected %q, got %q", want, buf.String())
+	}
+}
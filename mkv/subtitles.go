@@ -0,0 +1,433 @@
+package mkv
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// defaultCueDuration is used for subtitle blocks that carry no duration and
+// are followed by no other block.
+const defaultCueDuration = 3 * time.Second
+
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+type block struct {
+	pos      int64
+	track    uint64
+	timecode int64
+	duration int64
+	data     []byte
+}
+
+// Subtitles extracts the cues of a text subtitle track.
+//
+// When the file has a cue index for the track, only the clusters it points
+// to are read, otherwise every cluster is scanned, skipping blocks of other
+// tracks.
+func (f *File) Subtitles(number uint64) ([]Cue, error) {
+	track, err := f.Track(number)
+	if err != nil {
+		return nil, err
+	}
+
+	if !track.IsText() {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCodec, track.Codec)
+	}
+
+	err = f.loadCues()
+	if err != nil {
+		return nil, err
+	}
+
+	blocks, err := f.blocksFromCues(number)
+	if err != nil {
+		return nil, err
+	}
+
+	if blocks == nil {
+		blocks, err = f.scanBlocks(number)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	slices.SortFunc(blocks, func(a, b block) int {
+		return int(a.timecode - b.timecode)
+	})
+
+	cues := make([]Cue, 0, len(blocks))
+	for i, b := range blocks {
+		data, err := track.decode(b.data)
+		if err != nil {
+			return nil, err
+		}
+
+		text := cueText(track.Codec, data)
+		if text == "" {
+			continue
+		}
+
+		cue := Cue{
+			Start: f.toDuration(b.timecode),
+			Text:  text,
+		}
+
+		switch {
+		case b.duration >= 0:
+			cue.End = f.toDuration(b.timecode + b.duration)
+		case i+1 < len(blocks):
+			cue.End = f.toDuration(blocks[i+1].timecode)
+		default:
+			cue.End = cue.Start + defaultCueDuration
+		}
+
+		cues = append(cues, cue)
+	}
+
+	return cues, nil
+}
+
+func (f *File) toDuration(timecode int64) time.Duration {
+	return time.Duration(timecode * int64(f.TimecodeScale))
+}
+
+// blocksFromCues returns nil if the cue index doesn't reference the track.
+func (f *File) blocksFromCues(number uint64) ([]block, error) {
+	var blocks []block
+	seen := map[int64]bool{}
+	scanned := map[int64]bool{}
+
+	add := func(b block) {
+		if !seen[b.pos] {
+			seen[b.pos] = true
+			blocks = append(blocks, b)
+		}
+	}
+
+	for _, cue := range f.cues {
+		if cue.track != number {
+			continue
+		}
+
+		if blocks == nil {
+			blocks = []block{}
+		}
+
+		if cue.relative < 0 {
+			if scanned[cue.cluster] {
+				continue
+			}
+			scanned[cue.cluster] = true
+
+			found, _, err := f.scanCluster(cue.cluster, number)
+			if err != nil {
+				return nil, err
+			}
+			for _, b := range found {
+				add(b)
+			}
+			continue
+		}
+
+		cluster, clusterTime, err := f.clusterTimecode(cue.cluster)
+		if err != nil {
+			return nil, err
+		}
+
+		err = f.r.seek(cluster.dataPos + cue.relative)
+		if err != nil {
+			return nil, err
+		}
+
+		el, err := f.r.next()
+		if err != nil {
+			return nil, err
+		}
+
+		b, ok, err := f.readBlock(el, number)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			b.timecode += clusterTime
+			add(b)
+		}
+	}
+
+	return blocks, nil
+}
+
+func (f *File) clusterTimecode(pos int64) (element, int64, error) {
+	var cluster element
+	var timecode uint64
+
+	err := f.readAt(pos, idCluster, func(el element) error {
+		cluster = el
+		return f.r.each(el, func(el element) error {
+			if el.id != idTimecode {
+				return nil
+			}
+
+			var err error
+			timecode, err = f.r.readUint(el)
+			if err != nil {
+				return err
+			}
+			return errStop
+		})
+	})
+
+	return cluster, int64(timecode), err
+}
+
+func (f *File) scanBlocks(number uint64) ([]block, error) {
+	blocks := []block{}
+	end := f.segment.end()
+	pos := f.firstCluster
+
+	for pos > 0 && (end < 0 || pos < end) {
+		err := f.r.seek(pos)
+		if err != nil {
+			return nil, err
+		}
+
+		el, err := f.r.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if el.id != idCluster {
+			if el.size < 0 {
+				break
+			}
+			pos = el.end()
+			continue
+		}
+
+		found, next, err := f.scanCluster(pos, number)
+		if err != nil {
+			return nil, err
+		}
+
+		blocks = append(blocks, found...)
+		pos = next
+	}
+
+	return blocks, nil
+}
+
+// scanCluster returns the blocks of the given track in the cluster at pos,
+// and the position right after the cluster.
+func (f *File) scanCluster(pos int64, number uint64) ([]block, int64, error) {
+	var blocks []block
+	var clusterTime int64
+	next := int64(-1)
+
+	err := f.readAt(pos, idCluster, func(cluster element) error {
+		next = cluster.end()
+
+		return f.r.each(cluster, func(el element) error {
+			if cluster.size < 0 && isTopLevel(el.id) {
+				next = el.pos
+				return errStop
+			}
+
+			switch el.id {
+			case idTimecode:
+				n, err := f.r.readUint(el)
+				clusterTime = int64(n)
+				return err
+			case idSimpleBlock, idBlockGroup:
+				b, ok, err := f.readBlock(el, number)
+				if ok {
+					b.timecode += clusterTime
+					blocks = append(blocks, b)
+				}
+				return err
+			}
+
+			return nil
+		})
+	})
+
+	return blocks, next, err
+}
+
+func isTopLevel(id uint32) bool {
+	switch id {
+	case idCluster, idCues, idSeekHead, idInfo, idTracks,
+		0x1254C367, // Tags
+		0x1043A770, // Chapters
+		0x1941A469: // Attachments
+		return true
+	}
+	return false
+}
+
+// readBlock reads a SimpleBlock or BlockGroup if it belongs to the given
+// track. The returned timecode is relative to the cluster.
+func (f *File) readBlock(el element, number uint64) (block, bool, error) {
+	if el.id == idSimpleBlock {
+		return f.readBlockData(el, number)
+	}
+
+	if el.id != idBlockGroup {
+		return block{}, false, nil
+	}
+
+	var b block
+	ok := false
+	duration := int64(-1)
+
+	err := f.r.each(el, func(child element) error {
+		var err error
+		var n uint64
+
+		switch child.id {
+		case idBlock:
+			b, ok, err = f.readBlockData(child, number)
+			if err == nil && !ok {
+				return errStop
+			}
+		case idBlockDuration:
+			n, err = f.r.readUint(child)
+			duration = int64(n)
+		}
+
+		return err
+	})
+
+	b.pos = el.pos
+	b.duration = duration
+	return b, ok, err
+}
+
+func (f *File) readBlockData(el element, number uint64) (block, bool, error) {
+	err := f.r.seek(el.dataPos)
+	if err != nil {
+		return block{}, false, err
+	}
+
+	_, track, length, err := f.r.readVint()
+	if err != nil {
+		return block{}, false, err
+	}
+
+	if track != number {
+		return block{}, false, nil
+	}
+
+	var header [3]byte
+	err = f.r.readFull(header[:])
+	if err != nil {
+		return block{}, false, err
+	}
+
+	// laced blocks are not used for subtitles
+	if header[2]&0x06 != 0 {
+		return block{}, false, nil
+	}
+
+	size := el.size - int64(length) - int64(len(header))
+	if size < 0 || size > maxElementSize {
+		return block{}, false, fmt.Errorf("invalid block size %d", size)
+	}
+
+	data := make([]byte, size)
+	err = f.r.readFull(data)
+	if err != nil {
+		return block{}, false, err
+	}
+
+	b := block{
+		pos:      el.pos,
+		track:    track,
+		timecode: int64(int16(binary.BigEndian.Uint16(header[:2]))),
+		duration: -1,
+		data:     data,
+	}
+
+	return b, true, nil
+}
+
+func (t Track) decode(data []byte) ([]byte, error) {
+	if t.compression == nil {
+		return data, nil
+	}
+
+	switch t.compression.algo {
+	case 0:
+		r, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(io.LimitReader(r, maxElementSize))
+	case 3:
+		return append(slices.Clone(t.compression.settings), data...), nil
+	default:
+		return nil, fmt.Errorf("%w: compression algorithm %d", ErrUnsupportedCodec, t.compression.algo)
+	}
+}
+
+var (
+	assOverrides = regexp.MustCompile(`\{[^}]*\}`)
+	assEscapes   = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ")
+	blankLines   = regexp.MustCompile(`\n{2,}`)
+)
+
+func cueText(codec string, data []byte) string {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+
+	switch codec {
+	case "S_TEXT/ASS", "S_TEXT/SSA":
+		// ReadOrder, Layer, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+		fields := strings.SplitN(text, ",", 9)
+		text = fields[len(fields)-1]
+		text = assOverrides.ReplaceAllString(text, "")
+		text = assEscapes.Replace(text)
+	}
+
+	// WebVTT cue payloads can't contain blank lines or the timing arrow
+	text = blankLines.ReplaceAllString(strings.TrimSpace(text), "\n")
+	return strings.ReplaceAll(text, "-->", "->")
+}
+
+// WriteVTT writes cues as a WebVTT document.
+func WriteVTT(w io.Writer, cues []Cue) error {
+	bw := bufio.NewWriter(w)
+
+	_, err := bw.WriteString("WEBVTT\n\n")
+	if err != nil {
+		return err
+	}
+
+	for _, cue := range cues {
+		_, err = fmt.Fprintf(bw, "%s --> %s\n%s\n\n", vttTime(cue.Start), vttTime(cue.End), cue.Text)
+		if err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+func vttTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
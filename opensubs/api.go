@@ -1,23 +1,30 @@
 package opensubs
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
-	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"sync"
+
+	"github.com/igorcafe/anyflix/httpx"
 )
 
 type API struct {
 	BaseURL string
+	HTTP    *httpx.Client
 }
 
 func DefaultAPI() API {
 	return API{
-		"https://opensubtitles-v3.strem.io",
+		BaseURL: "https://opensubtitles-v3.strem.io",
 	}
 }
 
@@ -31,65 +38,134 @@ type Sub struct {
 	Encoding string `json:"SubEncoding"`
 }
 
-func (h API) Search(kind, imdbID, fileHash string) ([]Sub, error) {
+func (h API) Search(ctx context.Context, kind, imdbID, fileHash string) ([]Sub, error) {
 	slog.Debug("opensubsService.search", "kind", kind, "imdbID", imdbID, "fileHash", fileHash)
 
 	var subs searchResponse
-	url := h.BaseURL + "/subtitles/" + kind + "/" + imdbID + "videoHash=" + fileHash + ".json"
+	url := h.BaseURL + "/subtitles/" + kind + "/" + imdbID + "/videoHash=" + fileHash + ".json"
+
+	err := h.HTTP.GetJSON(ctx, url, &subs)
+	return subs.Subtitles, err
+}
+
+// MaxSubSize is the largest subtitle file Download accepts.
+const MaxSubSize = 5 * 1024 * 1024
+
+const downloadWorkers = 4
 
-	resp, err := http.Get(url)
+// Result is the outcome of downloading a single subtitle.
+type Result struct {
+	Sub  Sub
+	Path string
+	Err  error
+}
+
+// CacheDir returns the directory where downloaded subtitles are kept.
+func CacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	return filepath.Join(dir, "anyflix", "subtitles"), nil
+}
+
+// Download fetches subs into dir concurrently. Results are returned in the
+// same order as subs; the error joins every per-item error.
+func (h API) Download(ctx context.Context, dir string, subs ...Sub) ([]Result, error) {
+	results := make([]Result, len(subs))
+
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return results, err
+	}
+
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+
+	for range min(downloadWorkers, len(subs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				path, err := h.download(ctx, dir, subs[i])
+				results[i] = Result{Sub: subs[i], Path: path, Err: err}
+			}
+		}()
+	}
+
+	for i := range subs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	errs := []error{}
+	for _, res := range results {
+		if res.Err != nil {
+			errs = append(errs, res.Err)
+		}
+	}
+
+	return results, errors.Join(errs...)
+}
+
+// download saves a subtitle under a name derived from its content, so subs
+// that share a base name don't overwrite each other and the same sub found
+// through different URLs is only kept once.
+func (h API) download(ctx context.Context, dir string, sub Sub) (string, error) {
+	resp, err := h.HTTP.Get(ctx, sub.URL)
+	if err != nil {
+		return "", fmt.Errorf("download subtitle %s: %w", sub.URL, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 300 {
-		return nil, errors.New(resp.Status)
+		return "", fmt.Errorf("download subtitle %s: %s", sub.URL, resp.Status)
 	}
 
-	slog.Info("handleSearch", "method", "GET", "url", url, "status", resp.Status)
+	if resp.ContentLength > MaxSubSize {
+		return "", fmt.Errorf("download subtitle %s: too large (%d bytes)", sub.URL, resp.ContentLength)
+	}
 
-	err = json.NewDecoder(resp.Body).Decode(&subs)
-	return subs.Subtitles, err
-}
+	f, err := os.CreateTemp(dir, ".download-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
 
-func Download(dir string, subs ...Sub) ([]string, error) {
-	paths := []string{}
-
-	for _, sub := range subs {
-		func() {
-			filePath := filepath.Join(dir, path.Base(sub.URL))
-			_, err := os.Stat(filePath)
-			if err == nil {
-				slog.Info("subtitle already downloaded", "url", sub.URL)
-				paths = append(paths, filePath)
-				return
-			}
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, hash), io.LimitReader(resp.Body, MaxSubSize+1))
+	if err != nil {
+		return "", fmt.Errorf("download subtitle %s: %w", sub.URL, err)
+	}
 
-			resp, err := http.Get(sub.URL)
-			if err != nil {
-				slog.Error("failed to download subtitle", "url", sub.URL, "err", err)
-				return
-			}
-			defer resp.Body.Close()
+	if n > MaxSubSize {
+		return "", fmt.Errorf("download subtitle %s: too large (more than %d bytes)", sub.URL, MaxSubSize)
+	}
 
-			f, err := os.Create(filePath)
-			if err != nil {
-				slog.Error("failed to download subtitle", "url", sub.URL, "err", err)
-				return
-			}
-			defer f.Close()
+	err = f.Close()
+	if err != nil {
+		return "", err
+	}
 
-			_, err = io.Copy(f, resp.Body)
-			if err != nil {
-				slog.Error("failed to download subtitle", "url", sub.URL, "err", err)
-				return
-			}
+	filePath := filepath.Join(dir, subFileName(hash.Sum(nil), sub.URL))
+	err = os.Rename(f.Name(), filePath)
+	if err != nil {
+		return "", err
+	}
 
-			slog.Debug("saved subtitle", "filePath", filePath, "url", sub.URL)
-			paths = append(paths, filePath)
-		}()
+	slog.Debug("saved subtitle", "filePath", filePath, "url", sub.URL)
+	return filePath, nil
+}
+
+// subFileName names a subtitle after the hash of its content, keeping the
+// extension of its URL.
+func subFileName(sum []byte, rawURL string) string {
+	ext := ".srt"
+	if u, err := url.Parse(rawURL); err == nil && path.Ext(u.Path) != "" {
+		ext = path.Ext(u.Path)
 	}
 
-	return paths, nil
+	return hex.EncodeToString(sum[:16]) + ext
 }
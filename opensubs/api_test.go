@@ -0,0 +1,65 @@
+package opensubs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDownload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/big/"):
+			w.Write([]byte(strings.Repeat("a", MaxSubSize+1)))
+		case strings.HasPrefix(r.URL.Path, "/missing/"):
+			http.NotFound(w, r)
+		default:
+			w.Write([]byte(r.URL.Path))
+		}
+	}))
+	defer srv.Close()
+
+	subs := []Sub{
+		{URL: srv.URL + "/a/sub.srt"},
+		{URL: srv.URL + "/b/sub.srt"},
+		{URL: srv.URL + "/big/sub.srt"},
+		{URL: srv.URL + "/missing/sub.srt"},
+	}
+
+	results, err := API{}.Download(context.Background(), t.TempDir(), subs...)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+
+	if len(results) != len(subs) {
+		t.Fatalf("expected %d results, got %d", len(subs), len(results))
+	}
+
+	for i, res := range results[:2] {
+		if res.Err != nil {
+			t.Fatalf("expected no error for %s, got %v", subs[i].URL, res.Err)
+		}
+
+		b, err := os.ReadFile(res.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(b) != strings.TrimPrefix(subs[i].URL, srv.URL) {
+			t.Fatalf("unexpected content for %s: %s", subs[i].URL, b)
+		}
+	}
+
+	if results[0].Path == results[1].Path {
+		t.Fatalf("expected distinct paths, got %s twice", results[0].Path)
+	}
+
+	for _, res := range results[2:] {
+		if res.Err == nil {
+			t.Fatalf("expected error for %s, got nil", res.Sub.URL)
+		}
+	}
+}
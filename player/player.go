@@ -0,0 +1,171 @@
+// Package player launches the external video player.
+package player
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+
+	"github.com/igorcafe/anyflix/events"
+)
+
+const (
+	TopicStarted = "player.started"
+	TopicStopped = "player.stopped"
+)
+
+type Player struct {
+	// Cmd is the command line template, see config.Config.PlayerCmd.
+	Cmd string
+
+	Events *events.Bus
+
+	// running holds the players launched and not exited yet
+	mu      sync.Mutex
+	running map[*exec.Cmd]*process
+}
+
+type process struct {
+	url string
+	// exited is closed once the player exits
+	exited chan struct{}
+}
+
+// Playback is published when the player starts and stops.
+type Playback struct {
+	URL   string `json:"url"`
+	PID   int    `json:"pid"`
+	Error string `json:"error,omitempty"`
+}
+
+type Sub struct {
+	URL string
+}
+
+type Params struct {
+	URL  string
+	Subs []Sub
+}
+
+// Launch starts the player in the background.
+func (p *Player) Launch(params Params) error {
+	tmpl, err := template.New("player").Parse(p.Cmd)
+	if err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	err = tmpl.Execute(buf, params)
+	if err != nil {
+		return err
+	}
+
+	args := strings.Fields(buf.String())
+	if len(args) == 0 {
+		return errors.New("empty player command")
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	slog.Info("started player", "args", args)
+
+	proc := &process{url: params.URL, exited: make(chan struct{})}
+
+	p.mu.Lock()
+	if p.running == nil {
+		p.running = map[*exec.Cmd]*process{}
+	}
+	p.running[cmd] = proc
+	p.mu.Unlock()
+
+	playback := Playback{
+		URL: params.URL,
+		PID: cmd.Process.Pid,
+	}
+	p.Events.Publish(TopicStarted, playback)
+
+	go func() {
+		err := cmd.Wait()
+		slog.Info("player exited", "url", params.URL, "err", err)
+
+		p.mu.Lock()
+		delete(p.running, cmd)
+		p.mu.Unlock()
+		close(proc.exited)
+
+		if err != nil {
+			playback.Error = err.Error()
+		}
+		p.Events.Publish(TopicStopped, playback)
+	}()
+
+	return nil
+}
+
+// Stop asks the running players to quit, and kills the ones still running
+// once ctx is done.
+func (p *Player) Stop(ctx context.Context) error {
+	return p.stop(ctx, func(string) bool { return true })
+}
+
+// Restart stops the players playing params.URL and launches a new one in
+// their place, so it plays from the start. Nothing is launched when no
+// player was playing it.
+func (p *Player) Restart(ctx context.Context, params Params) error {
+	stopped := false
+	err := p.stop(ctx, func(url string) bool {
+		stopped = stopped || url == params.URL
+		return url == params.URL
+	})
+	if err != nil || !stopped {
+		return err
+	}
+
+	return p.Launch(params)
+}
+
+// stop is like Stop for the players whose URL matches.
+func (p *Player) stop(ctx context.Context, match func(url string) bool) error {
+	p.mu.Lock()
+	running := map[*exec.Cmd]chan struct{}{}
+	for cmd, proc := range p.running {
+		if match(proc.url) {
+			running[cmd] = proc.exited
+		}
+	}
+	p.mu.Unlock()
+
+	var errs []error
+
+	for cmd := range running {
+		err := cmd.Process.Signal(syscall.SIGTERM)
+		if err != nil {
+			slog.Warn("failed to stop player", "pid", cmd.Process.Pid, "err", err)
+		}
+	}
+
+	for cmd, exited := range running {
+		select {
+		case <-exited:
+		case <-ctx.Done():
+			slog.Warn("killing player", "pid", cmd.Process.Pid)
+			err := cmd.Process.Kill()
+			if err != nil && !errors.Is(err, os.ErrProcessDone) {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
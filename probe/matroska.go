@@ -0,0 +1,92 @@
+package probe
+
+import (
+	"io"
+	"strings"
+
+	"github.com/igorcafe/anyflix/mkv"
+)
+
+func probeMatroska(r io.ReadSeeker) (Info, error) {
+	f, err := mkv.Open(r)
+	if err != nil {
+		return Info{}, err
+	}
+
+	info := Info{
+		Container: f.DocType,
+		Duration:  f.Duration * float64(f.TimecodeScale) / 1e9,
+		Audio:     []Audio{},
+	}
+
+	for _, track := range f.Tracks {
+		switch track.Type {
+		case mkv.TrackVideo:
+			if info.Video != nil {
+				continue
+			}
+
+			info.Video = &Video{
+				Codec: matroskaCodec(track.Codec),
+			}
+
+			if track.Video != nil {
+				info.Video.Width = int(track.Video.Width)
+				info.Video.Height = int(track.Video.Height)
+				info.Video.HDR10 = track.Video.TransferCharacteristics == transferPQ
+				info.Video.HLG = track.Video.TransferCharacteristics == transferHLG
+				info.Video.DolbyVision = track.Video.DolbyVision
+			}
+
+		case mkv.TrackAudio:
+			audio := Audio{
+				Codec:    matroskaCodec(track.Codec),
+				Language: track.Language,
+			}
+
+			if track.Audio != nil {
+				audio.Channels = int(track.Audio.Channels)
+				audio.SampleRate = int(track.Audio.SampleRate)
+			}
+
+			info.Audio = append(info.Audio, audio)
+		}
+	}
+
+	return info, nil
+}
+
+var matroskaCodecs = map[string]string{
+	"V_MPEG4/ISO/AVC":  "h264",
+	"V_MPEGH/ISO/HEVC": "hevc",
+	"V_AV1":            "av1",
+	"V_VP8":            "vp8",
+	"V_VP9":            "vp9",
+	"V_MPEG4/ISO/ASP":  "mpeg4",
+	"V_MPEG2":          "mpeg2",
+	"A_AC3":            "ac3",
+	"A_EAC3":           "eac3",
+	"A_TRUEHD":         "truehd",
+	"A_FLAC":           "flac",
+	"A_OPUS":           "opus",
+	"A_VORBIS":         "vorbis",
+	"A_MPEG/L3":        "mp3",
+	"A_MPEG/L2":        "mp2",
+}
+
+func matroskaCodec(id string) string {
+	if codec, ok := matroskaCodecs[id]; ok {
+		return codec
+	}
+
+	switch {
+	case strings.HasPrefix(id, "A_AAC"):
+		return "aac"
+	case strings.HasPrefix(id, "A_DTS"):
+		return "dts"
+	case strings.HasPrefix(id, "A_PCM"):
+		return "pcm"
+	}
+
+	return strings.ToLower(id)
+}
@@ -0,0 +1,307 @@
+package probe
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxMoovSize caps how much of the moov box is loaded into memory.
+const maxMoovSize = 64 * 1024 * 1024
+
+var errNoMoov = errors.New("moov box not found")
+
+func probeMP4(r io.ReadSeeker, size int64) (Info, error) {
+	moov, err := readMoov(r, size)
+	if err != nil {
+		return Info{}, err
+	}
+
+	info := Info{
+		Container: "mp4",
+		Audio:     []Audio{},
+	}
+
+	eachBox(moov, func(typ string, data []byte) {
+		switch typ {
+		case "mvhd":
+			info.Duration = parseMvhd(data)
+		case "trak":
+			parseTrak(data, &info)
+		}
+	})
+
+	return info, nil
+}
+
+// readMoov walks the top level boxes, seeking over everything else (mdat is
+// often placed before moov), and loads the moov box.
+func readMoov(r io.ReadSeeker, size int64) ([]byte, error) {
+	var header [16]byte
+	pos := int64(0)
+
+	for pos+8 <= size {
+		_, err := r.Seek(pos, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = io.ReadFull(r, header[:8])
+		if err != nil {
+			return nil, err
+		}
+
+		boxSize := int64(binary.BigEndian.Uint32(header[:4]))
+		typ := string(header[4:8])
+		headerSize := int64(8)
+
+		switch boxSize {
+		case 0:
+			boxSize = size - pos
+		case 1:
+			_, err = io.ReadFull(r, header[8:16])
+			if err != nil {
+				return nil, err
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+
+		if boxSize < headerSize {
+			return nil, fmt.Errorf("invalid %q box size %d at %d", typ, boxSize, pos)
+		}
+
+		if typ == "moov" {
+			if boxSize-headerSize > maxMoovSize {
+				return nil, fmt.Errorf("moov box too large: %d bytes", boxSize)
+			}
+
+			moov := make([]byte, boxSize-headerSize)
+			_, err = io.ReadFull(r, moov)
+			return moov, err
+		}
+
+		pos += boxSize
+	}
+
+	return nil, errNoMoov
+}
+
+// eachBox calls fn for every well formed box in b.
+func eachBox(b []byte, fn func(typ string, data []byte)) {
+	for len(b) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(b))
+		typ := string(b[4:8])
+		header := uint64(8)
+
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return
+			}
+			size = binary.BigEndian.Uint64(b[8:16])
+			header = 16
+		}
+
+		if size < header || size > uint64(len(b)) {
+			return
+		}
+
+		fn(typ, b[header:size])
+		b = b[size:]
+	}
+}
+
+func parseMvhd(b []byte) float64 {
+	var timescale, duration uint64
+
+	switch {
+	case len(b) >= 32 && b[0] == 1:
+		timescale = uint64(binary.BigEndian.Uint32(b[20:24]))
+		duration = binary.BigEndian.Uint64(b[24:32])
+	case len(b) >= 20:
+		timescale = uint64(binary.BigEndian.Uint32(b[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(b[16:20]))
+	}
+
+	if timescale == 0 {
+		return 0
+	}
+	return float64(duration) / float64(timescale)
+}
+
+type sampleEntry struct {
+	typ         string
+	width       int
+	height      int
+	channels    int
+	sampleRate  int
+	transfer    int
+	dolbyVision bool
+}
+
+func parseTrak(b []byte, info *Info) {
+	var handler, lang string
+	var entry sampleEntry
+
+	eachBox(b, func(typ string, data []byte) {
+		if typ != "mdia" {
+			return
+		}
+
+		eachBox(data, func(typ string, data []byte) {
+			switch typ {
+			case "mdhd":
+				lang = parseMdhdLanguage(data)
+			case "hdlr":
+				if len(data) >= 12 {
+					handler = string(data[8:12])
+				}
+			case "minf":
+				eachBox(data, func(typ string, data []byte) {
+					if typ != "stbl" {
+						return
+					}
+					eachBox(data, func(typ string, data []byte) {
+						if typ == "stsd" && len(data) > 8 {
+							entry = parseSampleEntry(data[8:])
+						}
+					})
+				})
+			}
+		})
+	})
+
+	switch handler {
+	case "vide":
+		if info.Video != nil {
+			return
+		}
+
+		info.Video = &Video{
+			Codec:       mp4Codec(entry.typ),
+			Width:       entry.width,
+			Height:      entry.height,
+			HDR10:       entry.transfer == transferPQ,
+			HLG:         entry.transfer == transferHLG,
+			DolbyVision: entry.dolbyVision,
+		}
+
+	case "soun":
+		info.Audio = append(info.Audio, Audio{
+			Codec:      mp4Codec(entry.typ),
+			Language:   lang,
+			Channels:   entry.channels,
+			SampleRate: entry.sampleRate,
+		})
+	}
+}
+
+// parseMdhdLanguage decodes the packed ISO-639-2/T language code.
+func parseMdhdLanguage(b []byte) string {
+	offset := 20
+	if len(b) > 0 && b[0] == 1 {
+		offset = 32
+	}
+
+	if len(b) < offset+2 {
+		return "und"
+	}
+
+	packed := binary.BigEndian.Uint16(b[offset:])
+	lang := []byte{
+		byte(packed>>10&0x1F) + 0x60,
+		byte(packed>>5&0x1F) + 0x60,
+		byte(packed&0x1F) + 0x60,
+	}
+	return string(lang)
+}
+
+// parseSampleEntry reads the first entry of a stsd box.
+func parseSampleEntry(b []byte) sampleEntry {
+	var entry sampleEntry
+
+	eachBox(b, func(typ string, data []byte) {
+		if entry.typ != "" {
+			return
+		}
+		entry.typ = typ
+
+		switch typ {
+		case "dvh1", "dvhe", "dva1", "dvav":
+			entry.dolbyVision = true
+		}
+
+		// visual sample entries have 78 bytes of fixed fields
+		if len(data) >= 78 && isVideoEntry(typ) {
+			entry.width = int(binary.BigEndian.Uint16(data[24:26]))
+			entry.height = int(binary.BigEndian.Uint16(data[26:28]))
+
+			eachBox(data[78:], func(typ string, data []byte) {
+				switch typ {
+				case "colr":
+					if len(data) >= 8 && string(data[:4]) == "nclx" {
+						entry.transfer = int(binary.BigEndian.Uint16(data[6:8]))
+					}
+				case "dvcC", "dvvC":
+					entry.dolbyVision = true
+				}
+			})
+			return
+		}
+
+		// audio sample entries have at least 28 bytes of fixed fields
+		if len(data) >= 28 {
+			entry.channels = int(binary.BigEndian.Uint16(data[16:18]))
+			entry.sampleRate = int(binary.BigEndian.Uint32(data[24:28]) >> 16)
+		}
+	})
+
+	return entry
+}
+
+func isVideoEntry(typ string) bool {
+	switch typ {
+	case "avc1", "avc3", "hvc1", "hev1", "dvh1", "dvhe", "dva1", "dvav",
+		"av01", "vp08", "vp09", "mp4v", "encv":
+		return true
+	}
+	return false
+}
+
+var mp4Codecs = map[string]string{
+	"avc1": "h264",
+	"avc3": "h264",
+	"dva1": "h264",
+	"dvav": "h264",
+	"hvc1": "hevc",
+	"hev1": "hevc",
+	"dvh1": "hevc",
+	"dvhe": "hevc",
+	"av01": "av1",
+	"vp08": "vp8",
+	"vp09": "vp9",
+	"mp4v": "mpeg4",
+	"mp4a": "aac",
+	"ac-3": "ac3",
+	"ec-3": "eac3",
+	"Opus": "opus",
+	"fLaC": "flac",
+	".mp3": "mp3",
+	"alac": "alac",
+	"dtsc": "dts",
+	"dtsh": "dts",
+	"dtsl": "dts",
+	"encv": "encrypted",
+	"enca": "encrypted",
+}
+
+func mp4Codec(typ string) string {
+	if codec, ok := mp4Codecs[typ]; ok {
+		return codec
+	}
+	return typ
+}
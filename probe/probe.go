@@ -0,0 +1,110 @@
+// Package probe reads container headers to describe a media file before it
+// is played.
+package probe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+var ErrUnknownContainer = errors.New("unknown container")
+
+type Info struct {
+	Container string  `json:"container"`
+	Duration  float64 `json:"duration"`
+	Video     *Video  `json:"video,omitempty"`
+	Audio     []Audio `json:"audio"`
+
+	BrowserPlayable bool     `json:"browserPlayable"`
+	Warnings        []string `json:"warnings"`
+}
+
+type Video struct {
+	Codec       string `json:"codec"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	HDR10       bool   `json:"hdr10"`
+	HLG         bool   `json:"hlg"`
+	DolbyVision bool   `json:"dolbyVision"`
+}
+
+type Audio struct {
+	Codec      string `json:"codec"`
+	Language   string `json:"language"`
+	Channels   int    `json:"channels"`
+	SampleRate int    `json:"sampleRate"`
+}
+
+// transfer characteristics from ITU-T H.273
+const (
+	transferPQ  = 16
+	transferHLG = 18
+)
+
+// Probe detects the container of r and reads its headers. size is the total
+// length of the file.
+func Probe(r io.ReadSeeker, size int64) (Info, error) {
+	var magic [8]byte
+
+	_, err := io.ReadFull(r, magic[:])
+	if err != nil {
+		return Info{}, fmt.Errorf("%w: %v", ErrUnknownContainer, err)
+	}
+
+	var info Info
+
+	switch {
+	case bytes.Equal(magic[:4], []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		info, err = probeMatroska(r)
+	case string(magic[4:8]) == "ftyp":
+		info, err = probeMP4(r, size)
+	default:
+		return Info{}, ErrUnknownContainer
+	}
+	if err != nil {
+		return Info{}, err
+	}
+
+	info.check()
+	return info, nil
+}
+
+var (
+	browserVideoCodecs = []string{"h264", "vp8", "vp9", "av1"}
+	browserAudioCodecs = []string{"aac", "mp3", "opus", "vorbis", "flac"}
+)
+
+// check fills BrowserPlayable and Warnings with a best guess of what
+// mainstream browsers can play.
+func (info *Info) check() {
+	info.BrowserPlayable = true
+	info.Warnings = []string{}
+
+	warn := func(format string, args ...any) {
+		info.BrowserPlayable = false
+		info.Warnings = append(info.Warnings, fmt.Sprintf(format, args...))
+	}
+
+	if info.Container == "matroska" {
+		warn("matroska files only play in some browsers")
+	}
+
+	if info.Video != nil {
+		if !slices.Contains(browserVideoCodecs, info.Video.Codec) {
+			warn("video codec %s is not supported by most browsers", info.Video.Codec)
+		}
+
+		if info.Video.DolbyVision || info.Video.HDR10 || info.Video.HLG {
+			info.Warnings = append(info.Warnings, "HDR video may look washed out on SDR displays")
+		}
+	}
+
+	if len(info.Audio) > 0 && !slices.ContainsFunc(info.Audio, func(a Audio) bool {
+		return slices.Contains(browserAudioCodecs, a.Codec)
+	}) {
+		warn("audio codec %s is not supported by most browsers", info.Audio[0].Codec)
+	}
+}
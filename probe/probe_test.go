@@ -0,0 +1,90 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func box(typ string, payload ...[]byte) []byte {
+	data := bytes.Join(payload, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(data)))
+	return append(append(b, typ...), data...)
+}
+
+func be16(n int) []byte { return binary.BigEndian.AppendUint16(nil, uint16(n)) }
+func be32(n int) []byte { return binary.BigEndian.AppendUint32(nil, uint32(n)) }
+
+func testMP4() []byte {
+	mvhd := box("mvhd", make([]byte, 12), be32(1000), be32(5400000), make([]byte, 80))
+
+	videoEntry := bytes.Join([][]byte{
+		make([]byte, 24), be16(3840), be16(2160), make([]byte, 50),
+		box("hvcC", make([]byte, 4)),
+		box("colr", []byte("nclx"), be16(9), be16(16), be16(9), []byte{0}),
+	}, nil)
+	video := box("trak",
+		box("tkhd", make([]byte, 84)),
+		box("mdia",
+			box("mdhd", make([]byte, 20), be16(0x55c4), be16(0)),
+			box("hdlr", make([]byte, 8), []byte("vide"), make([]byte, 12)),
+			box("minf", box("stbl", box("stsd", make([]byte, 4), be32(1), box("hvc1", videoEntry)))),
+		),
+	)
+
+	audioEntry := bytes.Join([][]byte{make([]byte, 16), be16(6), be16(16), make([]byte, 4), be32(48000 << 16)}, nil)
+	// "por" packed as ISO-639-2/T
+	lang := (('p' - 0x60) << 10) | (('o' - 0x60) << 5) | ('r' - 0x60)
+	audio := box("trak",
+		box("mdia",
+			box("mdhd", make([]byte, 20), be16(int(lang)), be16(0)),
+			box("hdlr", make([]byte, 8), []byte("soun"), make([]byte, 12)),
+			box("minf", box("stbl", box("stsd", make([]byte, 4), be32(1), box("ec-3", audioEntry)))),
+		),
+	)
+
+	return bytes.Join([][]byte{
+		box("ftyp", []byte("isom"), be32(0)),
+		box("mdat", make([]byte, 1024)),
+		box("moov", mvhd, video, audio),
+	}, nil)
+}
+
+func TestProbeMP4(t *testing.T) {
+	b := testMP4()
+
+	info, err := Probe(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if info.Container != "mp4" || info.Duration != 5400 {
+		t.Fatalf("expected mp4 with 5400s, got %s with %vs", info.Container, info.Duration)
+	}
+
+	wantVideo := Video{Codec: "hevc", Width: 3840, Height: 2160, HDR10: true}
+	if info.Video == nil || *info.Video != wantVideo {
+		t.Fatalf("expected video %+v, got %+v", wantVideo, info.Video)
+	}
+
+	wantAudio := Audio{Codec: "eac3", Language: "por", Channels: 6, SampleRate: 48000}
+	if len(info.Audio) != 1 || info.Audio[0] != wantAudio {
+		t.Fatalf("expected audio %+v, got %+v", wantAudio, info.Audio)
+	}
+
+	if info.BrowserPlayable {
+		t.Fatalf("expected hevc/eac3 not to be browser playable")
+	}
+
+	if len(info.Warnings) != 3 {
+		t.Fatalf("expected 3 warnings, got %q", info.Warnings)
+	}
+}
+
+func TestProbeUnknown(t *testing.T) {
+	b := []byte("not a media file at all")
+	_, err := Probe(bytes.NewReader(b), int64(len(b)))
+	if err != ErrUnknownContainer {
+		t.Fatalf("expected ErrUnknownContainer, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package source
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/igorcafe/anyflix/db"
+)
+
+// Manual finds the torrents that were added by hand for a title.
+type Manual struct{}
+
+func (Manual) Find(ctx context.Context, kind, id string) ([]Stream, error) {
+	imdbID, season, episode, err := ParseVideoID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	manual, err := db.FindManualStreams(imdbID, season, episode)
+	if err != nil {
+		return nil, err
+	}
+
+	streams := []Stream{}
+	for _, m := range manual {
+		s := Stream{
+			Type:     KindTorrent,
+			Name:     "manual",
+			Title:    fmt.Sprintf("%s\n%.2f GB", m.Name, float64(m.Size)/1e9),
+			InfoHash: m.InfoHash,
+			FileIdx:  m.FileIdx,
+		}
+
+		for _, tr := range m.Trackers {
+			s.Sources = append(s.Sources, "tracker:"+tr)
+		}
+
+		streams = append(streams, s)
+	}
+
+	return streams, nil
+}
@@ -1,57 +1,122 @@
 package source
 
 import (
-	"encoding/json"
-	"errors"
-	"log"
+	"context"
+	"fmt"
 	"log/slog"
-	"net/http"
 	"path"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/igorcafe/anyflix/config"
+	"github.com/igorcafe/anyflix/db"
+	"github.com/igorcafe/anyflix/httpx"
 )
 
 type Source struct {
 	BaseURL string
+	HTTP    *httpx.Client
 }
 
 type FindSourceResponse struct {
 	Streams []Stream `json:"streams"`
 }
 
+// Stream kinds, see Stream.Kind.
+const (
+	KindTorrent  = "torrent"
+	KindURL      = "url"
+	KindExternal = "external"
+	KindYouTube  = "youtube"
+	KindLocal    = "local"
+)
+
 type Stream struct {
+	// Type is one of the Kind constants. It is filled by SourceMux.Find.
+	Type string `json:"type"`
+
 	Name     string `json:"name"`
 	Title    string `json:"title"`
 	InfoHash string `json:"infoHash"`
 	FileIdx  int    `json:"fileIdx"`
+
+	// URL is a direct video link, ExternalURL a page to be opened in the
+	// browser and YtID a YouTube video id.
+	URL         string `json:"url,omitempty"`
+	ExternalURL string `json:"externalUrl,omitempty"`
+	YtID        string `json:"ytId,omitempty"`
+
+	// Sources are peer discovery hints for torrents, like
+	// "tracker:udp://tracker.example.com:80" or "dht:<infohash>".
+	Sources       []string      `json:"sources,omitempty"`
+	BehaviorHints BehaviorHints `json:"behaviorHints"`
+
+	// LibraryID is set for files in the local library.
+	LibraryID int64 `json:"libraryId,omitempty"`
+
+	// Health is the last known health of the swarm of a torrent.
+	Health *db.SwarmHealth `json:"health,omitempty"`
 }
 
-func (api Source) Find(kind, imdbID string) ([]Stream, error) {
-	var res FindSourceResponse
+type BehaviorHints struct {
+	BingeGroup  string `json:"bingeGroup,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+	VideoSize   int64  `json:"videoSize,omitempty"`
+	NotWebReady bool   `json:"notWebReady,omitempty"`
 
-	url := ManifestToBaseURL(api.BaseURL) + "/stream/" + kind + "/" + imdbID + ".json"
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
+	// ProxyHeaders must be sent along with URL requests, which is only
+	// possible through a proxy.
+	ProxyHeaders *ProxyHeaders `json:"proxyHeaders,omitempty"`
+}
 
-	ua := "Mozilla/5.0 (X11; Linux x86_64; rv:133.0) Gecko/20100101 Firefox/133.0"
-	req.Header.Set("User-Agent", ua)
+type ProxyHeaders struct {
+	Request  map[string]string `json:"request,omitempty"`
+	Response map[string]string `json:"response,omitempty"`
+}
 
-	slog.Info("TorrentSource.Find", "url", url)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
+// Kind tells how the stream is played.
+func (s Stream) Kind() string {
+	switch {
+	case s.LibraryID != 0 && s.InfoHash == "":
+		return KindLocal
+	case s.InfoHash != "":
+		return KindTorrent
+	case s.URL != "":
+		return KindURL
+	case s.YtID != "":
+		return KindYouTube
+	case s.ExternalURL != "":
+		return KindExternal
+	default:
+		return ""
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode != 200 {
-		// TODO
-		return nil, errors.New(resp.Status)
+// Trackers returns the tracker URLs listed in the stream sources.
+func (s Stream) Trackers() []string {
+	trackers := []string{}
+	for _, src := range s.Sources {
+		if tr, ok := strings.CutPrefix(src, "tracker:"); ok {
+			trackers = append(trackers, tr)
+		}
 	}
+	return trackers
+}
+
+// Finder finds streams for a title. kind is either "movie" or "series" and
+// imdbID is a stremio video id, like tt0944947:1:2 for episodes.
+type Finder interface {
+	Find(ctx context.Context, kind, imdbID string) ([]Stream, error)
+}
+
+func (api Source) Find(ctx context.Context, kind, imdbID string) ([]Stream, error) {
+	var res FindSourceResponse
+
+	url := ManifestToBaseURL(api.BaseURL) + "/stream/" + kind + "/" + imdbID + ".json"
 
-	err = json.NewDecoder(resp.Body).Decode(&res)
+	slog.Info("TorrentSource.Find", "url", url)
+	err := api.HTTP.GetJSON(ctx, url, &res)
 	if err != nil {
 		return nil, err
 	}
@@ -60,16 +125,36 @@ func (api Source) Find(kind, imdbID string) ([]Stream, error) {
 
 type SourceMux struct {
 	Addons []config.Addon
+	// Local sources are listed before any addon results.
+	Local []Finder
+	HTTP  *httpx.Client
 }
 
 // TODO: concurrency
-func (mux SourceMux) Find(kind, imdbID string) ([]Stream, error) {
+func (mux SourceMux) Find(ctx context.Context, kind, imdbID string) ([]Stream, error) {
 	var streams []Stream
 
+	for _, local := range mux.Local {
+		_streams, err := local.Find(ctx, kind, imdbID)
+		if err != nil {
+			return nil, err
+		}
+
+		streams = append(streams, _streams...)
+	}
+
+	// only what is on disk can be played without network access
+	if httpx.IsOffline() {
+		streams = slices.DeleteFunc(streams, func(s Stream) bool {
+			return s.Type != KindLocal
+		})
+		return streams, nil
+	}
+
 	for _, addon := range mux.Addons {
 		url := addon.Manifest
-		torrentSrc := Source{BaseURL: url}
-		_streams, err := torrentSrc.Find(kind, imdbID)
+		torrentSrc := Source{BaseURL: url, HTTP: mux.HTTP}
+		_streams, err := torrentSrc.Find(ctx, kind, imdbID)
 		if err != nil {
 			return nil, err
 		}
@@ -77,9 +162,43 @@ func (mux SourceMux) Find(kind, imdbID string) ([]Stream, error) {
 		streams = append(streams, _streams...)
 	}
 
+	for i := range streams {
+		streams[i].Type = streams[i].Kind()
+	}
+
+	// nothing to play
+	streams = slices.DeleteFunc(streams, func(s Stream) bool {
+		return s.Type == ""
+	})
+
 	return streams, nil
 }
 
+// ParseVideoID splits a stremio video id into its IMDb id, season and
+// episode.
+func ParseVideoID(id string) (string, int, int, error) {
+	parts := strings.Split(id, ":")
+
+	switch len(parts) {
+	case 1:
+		return parts[0], 0, 0, nil
+	case 3:
+		season, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return "", 0, 0, fmt.Errorf("invalid season in %q", id)
+		}
+
+		episode, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return "", 0, 0, fmt.Errorf("invalid episode in %q", id)
+		}
+
+		return parts[0], season, episode, nil
+	default:
+		return "", 0, 0, fmt.Errorf("invalid video id %q", id)
+	}
+}
+
 func ManifestToBaseURL(manifest string) string {
 	return strings.TrimSuffix(manifest, "/"+path.Base(manifest))
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/igorcafe/anyflix/opensubs"
+	"github.com/igorcafe/anyflix/player"
+	"github.com/igorcafe/anyflix/source"
+	"github.com/igorcafe/anyflix/torrent"
+)
+
+const (
+	// subsTimeout bounds how long launching the player waits for subtitles.
+	subsTimeout = 15 * time.Second
+
+	// maxSubsPerLang is how many subtitles of each language the player gets.
+	maxSubsPerLang = 3
+)
+
+// playerSubs downloads the subtitles of a torrent stream in langs, for the
+// player to load along with the video. Failures are only logged, the video
+// plays without subtitles then.
+func playerSubs(ctx context.Context, torrents *torrent.Service, api opensubs.API, langs []string, kind, id string, stream source.Stream) []player.Sub {
+	if len(langs) == 0 || id == "" || stream.Kind() != source.KindTorrent {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, subsTimeout)
+	defer cancel()
+
+	hash, err := torrents.FileHash(ctx, stream.InfoHash, stream.FileIdx)
+	if err != nil {
+		slog.Warn("failed to hash file for subtitles", "infoHash", stream.InfoHash, "err", err)
+		return nil
+	}
+
+	found, err := api.Search(ctx, kind, id, hash)
+	if err != nil {
+		slog.Warn("failed to search subtitles", "id", id, "err", err)
+		return nil
+	}
+
+	perLang := map[string]int{}
+	subs := []opensubs.Sub{}
+	for _, sub := range found {
+		if slices.Contains(langs, sub.Lang) && perLang[sub.Lang] < maxSubsPerLang {
+			perLang[sub.Lang]++
+			subs = append(subs, sub)
+		}
+	}
+	if len(subs) == 0 {
+		return nil
+	}
+
+	dir, err := opensubs.CacheDir()
+	if err != nil {
+		slog.Warn("failed to find subtitles cache dir", "err", err)
+		return nil
+	}
+
+	results, err := api.Download(ctx, dir, subs...)
+	if err != nil {
+		slog.Warn("failed to download some subtitles", "id", id, "err", err)
+	}
+
+	playerSubs := []player.Sub{}
+	for _, res := range results {
+		if res.Err == nil {
+			playerSubs = append(playerSubs, player.Sub{URL: res.Path})
+		}
+	}
+	return playerSubs
+}
@@ -0,0 +1,267 @@
+package torrent
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent/types/infohash"
+	"github.com/igorcafe/anyflix/config"
+	"github.com/igorcafe/anyflix/errorsx"
+	"golang.org/x/time/rate"
+)
+
+// minBurst is the smallest burst given to the rate limiters. The client
+// can't send chunks bigger than the upload burst, and peers ask for 16 KiB
+// ones.
+const minBurst = 64 * 1024
+
+// bandwidth holds the rate limiters of the client, which are adjusted in
+// place as the schedules and the streaming state change.
+type bandwidth struct {
+	download *rate.Limiter
+	upload   *rate.Limiter
+
+	mu  sync.Mutex
+	cfg config.BandwidthConfig
+	// paused holds the torrents kept from downloading while something else
+	// is streamed
+	paused map[infohash.T]bool
+}
+
+func newBandwidth(cfg config.BandwidthConfig) (*bandwidth, error) {
+	err := validateBandwidth(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &bandwidth{
+		download: rate.NewLimiter(rate.Inf, minBurst),
+		upload:   rate.NewLimiter(rate.Inf, minBurst),
+		cfg:      cfg,
+		paused:   map[infohash.T]bool{},
+	}, nil
+}
+
+// Bandwidth describes the bandwidth limits of the client.
+type Bandwidth struct {
+	Config config.BandwidthConfig `json:"config"`
+	// Limits are the limits in effect.
+	Limits    config.RateLimits `json:"limits"`
+	Streaming bool              `json:"streaming"`
+	// Paused counts the background downloads paused while streaming.
+	Paused int `json:"paused"`
+	// Schedule is the index of the schedule in effect, or -1.
+	Schedule int `json:"schedule"`
+}
+
+// Bandwidth reports the bandwidth config and the limits it results in right
+// now.
+func (h *Service) Bandwidth() Bandwidth {
+	h.bandwidth.mu.Lock()
+	cfg := h.bandwidth.cfg
+	paused := len(h.bandwidth.paused)
+	h.bandwidth.mu.Unlock()
+
+	streaming := h.streaming()
+	limits, schedule := limitsAt(cfg, time.Now(), streaming)
+
+	return Bandwidth{
+		Config:    cfg,
+		Limits:    limits,
+		Streaming: streaming,
+		Paused:    paused,
+		Schedule:  schedule,
+	}
+}
+
+// SetBandwidth replaces the bandwidth config and applies it right away. It
+// fails with errorsx.Invalid for malformed schedules.
+func (h *Service) SetBandwidth(cfg config.BandwidthConfig) error {
+	err := validateBandwidth(cfg)
+	if err != nil {
+		return err
+	}
+
+	h.bandwidth.mu.Lock()
+	h.bandwidth.cfg = cfg
+	h.bandwidth.mu.Unlock()
+
+	h.applyBandwidth(time.Now())
+	return nil
+}
+
+func (h *Service) applyBandwidth(now time.Time) {
+	b := h.bandwidth
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	streamed := h.readers.keys()
+
+	limits, _ := limitsAt(b.cfg, now, len(streamed) > 0)
+	setLimit(b.download, limits.DownloadKiBps)
+	setLimit(b.upload, limits.UploadKiBps)
+
+	h.downloadsMu.Lock()
+	pause := backgroundTorrents(h.downloads, streamed)
+	h.downloadsMu.Unlock()
+
+	for ih := range pause {
+		if b.paused[ih] {
+			continue
+		}
+		if t, ok := h.client.Torrent(ih); ok {
+			t.DisallowDataDownload()
+			b.paused[ih] = true
+			slog.Debug("paused background download", "infoHash", ih.HexString())
+		}
+	}
+
+	for ih := range b.paused {
+		if pause[ih] {
+			continue
+		}
+		delete(b.paused, ih)
+		if t, ok := h.client.Torrent(ih); ok {
+			t.AllowDataDownload()
+			slog.Debug("resumed background download", "infoHash", ih.HexString())
+		}
+	}
+}
+
+// backgroundTorrents returns the torrents with files being downloaded but
+// none being streamed, which are paused while something else is streamed so
+// streams get the whole bandwidth.
+func backgroundTorrents(downloads map[fileKey]bool, streamed []fileKey) map[infohash.T]bool {
+	background := map[infohash.T]bool{}
+	if len(streamed) == 0 {
+		return background
+	}
+
+	for key := range downloads {
+		background[key.infoHash] = true
+	}
+	for _, key := range streamed {
+		delete(background, key.infoHash)
+	}
+	return background
+}
+
+// applyBandwidthEvery keeps the limits in line with the schedules and the
+// streaming state.
+func (h *Service) applyBandwidthEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		h.applyBandwidth(now)
+	}
+}
+
+func (h *Service) streaming() bool {
+	return len(h.readers.keys()) > 0
+}
+
+func setLimit(l *rate.Limiter, kibps int) {
+	if kibps <= 0 {
+		l.SetLimit(rate.Inf)
+		return
+	}
+
+	bps := kibps * 1024
+	l.SetBurst(max(bps, minBurst))
+	l.SetLimit(rate.Limit(bps))
+}
+
+// limitsAt returns the limits in effect at now, and the index of the
+// schedule they come from or -1.
+func limitsAt(cfg config.BandwidthConfig, now time.Time, streaming bool) (config.RateLimits, int) {
+	limits, schedule := cfg.Limits, -1
+	minute := now.Hour()*60 + now.Minute()
+
+	for i, s := range cfg.Schedules {
+		from, _ := parseClock(s.From)
+		to, _ := parseClock(s.To)
+
+		if inWindow(minute, from, to) {
+			limits, schedule = s.Limits, i
+			break
+		}
+	}
+
+	if !streaming {
+		limits.DownloadKiBps = lowerLimit(limits.DownloadKiBps, cfg.Background.DownloadKiBps)
+		limits.UploadKiBps = lowerLimit(limits.UploadKiBps, cfg.Background.UploadKiBps)
+	}
+
+	return limits, schedule
+}
+
+// inWindow reports whether minute is in [from, to), wrapping around
+// midnight. Equal ends cover the whole day.
+func inWindow(minute, from, to int) bool {
+	switch {
+	case from == to:
+		return true
+	case from < to:
+		return minute >= from && minute < to
+	default:
+		return minute >= from || minute < to
+	}
+}
+
+// lowerLimit returns the stricter of two limits, where zero is unlimited.
+func lowerLimit(a, b int) int {
+	switch {
+	case a <= 0:
+		return max(b, 0)
+	case b <= 0:
+		return a
+	default:
+		return min(a, b)
+	}
+}
+
+// parseClock parses a time of day like "23:30" into minutes since midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%w time of day %q, expected HH:MM", errorsx.Invalid, s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func validateBandwidth(cfg config.BandwidthConfig) error {
+	err := validateLimits(cfg.Limits)
+	if err != nil {
+		return fmt.Errorf("bandwidth limits: %w", err)
+	}
+
+	err = validateLimits(cfg.Background)
+	if err != nil {
+		return fmt.Errorf("background bandwidth limits: %w", err)
+	}
+
+	for i, s := range cfg.Schedules {
+		for _, clock := range []string{s.From, s.To} {
+			if _, err := parseClock(clock); err != nil {
+				return fmt.Errorf("bandwidth schedule %d: %w", i, err)
+			}
+		}
+
+		err := validateLimits(s.Limits)
+		if err != nil {
+			return fmt.Errorf("bandwidth schedule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func validateLimits(l config.RateLimits) error {
+	if l.DownloadKiBps < 0 || l.UploadKiBps < 0 {
+		return fmt.Errorf("%w negative rate limit", errorsx.Invalid)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package torrent
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent/types/infohash"
+	"github.com/igorcafe/anyflix/config"
+	"github.com/igorcafe/anyflix/errorsx"
+)
+
+func TestLimitsAt(t *testing.T) {
+	cfg := config.BandwidthConfig{
+		Limits:     config.RateLimits{DownloadKiBps: 1000, UploadKiBps: 100},
+		Background: config.RateLimits{DownloadKiBps: 200},
+		Schedules: []config.BandwidthSchedule{
+			{From: "23:00", To: "07:00", Limits: config.RateLimits{}},
+			{From: "18:00", To: "23:30", Limits: config.RateLimits{DownloadKiBps: 500, UploadKiBps: 50}},
+		},
+	}
+
+	at := func(clock string) time.Time {
+		t, _ := time.Parse("15:04", clock)
+		return t
+	}
+
+	tests := []struct {
+		clock     string
+		streaming bool
+		want      config.RateLimits
+		schedule  int
+	}{
+		{clock: "12:00", streaming: true, want: config.RateLimits{DownloadKiBps: 1000, UploadKiBps: 100}, schedule: -1},
+		{clock: "12:00", streaming: false, want: config.RateLimits{DownloadKiBps: 200, UploadKiBps: 100}, schedule: -1},
+		{clock: "02:00", streaming: true, want: config.RateLimits{}, schedule: 0},
+		{clock: "02:00", streaming: false, want: config.RateLimits{DownloadKiBps: 200}, schedule: 0},
+		{clock: "23:15", streaming: true, want: config.RateLimits{}, schedule: 0},
+		{clock: "20:00", streaming: true, want: config.RateLimits{DownloadKiBps: 500, UploadKiBps: 50}, schedule: 1},
+		{clock: "07:00", streaming: true, want: config.RateLimits{DownloadKiBps: 1000, UploadKiBps: 100}, schedule: -1},
+	}
+
+	for _, tt := range tests {
+		got, schedule := limitsAt(cfg, at(tt.clock), tt.streaming)
+		if got != tt.want || schedule != tt.schedule {
+			t.Errorf("at %s streaming=%v: expected %+v from %d, got %+v from %d",
+				tt.clock, tt.streaming, tt.want, tt.schedule, got, schedule)
+		}
+	}
+}
+
+func TestValidateBandwidth(t *testing.T) {
+	for _, clock := range []string{"", "24:00", "12:60", "noon"} {
+		cfg := config.BandwidthConfig{
+			Schedules: []config.BandwidthSchedule{{From: clock, To: "08:00"}},
+		}
+
+		err := validateBandwidth(cfg)
+		if !errors.Is(err, errorsx.Invalid) {
+			t.Errorf("expected invalid error for %q, got %v", clock, err)
+		}
+	}
+
+	negative := []config.BandwidthConfig{
+		{Limits: config.RateLimits{DownloadKiBps: -1}},
+		{Background: config.RateLimits{UploadKiBps: -1}},
+		{Schedules: []config.BandwidthSchedule{{From: "08:00", To: "18:00", Limits: config.RateLimits{UploadKiBps: -5}}}},
+	}
+	for _, cfg := range negative {
+		err := validateBandwidth(cfg)
+		if !errors.Is(err, errorsx.Invalid) {
+			t.Errorf("expected invalid error for %+v, got %v", cfg, err)
+		}
+	}
+
+	err := validateBandwidth(config.BandwidthConfig{Limits: config.RateLimits{DownloadKiBps: 100}})
+	if err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestBackgroundTorrents(t *testing.T) {
+	a, b, c := infohash.T{1}, infohash.T{2}, infohash.T{3}
+	downloads := map[fileKey]bool{{a, 0}: true, {b, 0}: true, {b, 1}: true}
+
+	got := backgroundTorrents(downloads, nil)
+	if len(got) != 0 {
+		t.Fatalf("expected nothing paused without streams, got %v", got)
+	}
+
+	got = backgroundTorrents(downloads, []fileKey{{b, 2}, {c, 0}})
+	if len(got) != 1 || !got[a] {
+		t.Fatalf("expected only %v paused, got %v", a, got)
+	}
+}
@@ -0,0 +1,331 @@
+package torrent
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anacrolix/torrent/iplist"
+)
+
+// blocklist is the IP blocklist of the client. The client only takes one
+// when it's created, so the ranges are swapped in place on reload.
+type blocklist struct {
+	path    string
+	ranges  atomic.Pointer[blockRanges]
+	blocked atomic.Int64
+
+	mu       sync.Mutex
+	modTime  time.Time
+	loadedAt time.Time
+	err      error
+}
+
+// blockRanges are sorted ranges that don't overlap, kept apart by family so
+// they compare byte by byte.
+type blockRanges struct {
+	v4, v6 []iplist.Range
+}
+
+// Blocklist describes the IP blocklist of the client.
+type Blocklist struct {
+	Path   string `json:"path"`
+	Ranges int    `json:"ranges"`
+	// Blocked counts the connections refused and the peers ignored because
+	// of the blocklist.
+	Blocked  int64     `json:"blocked"`
+	LoadedAt time.Time `json:"loadedAt"`
+	// Error is why the last reload failed, in which case the previous
+	// ranges are still in use.
+	Error string `json:"error,omitempty"`
+}
+
+func newBlocklist(path string) (*blocklist, error) {
+	b := &blocklist{path: path}
+	if path == "" {
+		return b, nil
+	}
+
+	_, err := b.reload()
+	return b, err
+}
+
+// Lookup implements iplist.Ranger.
+func (b *blocklist) Lookup(ip net.IP) (iplist.Range, bool) {
+	ranges := b.ranges.Load()
+	if ranges == nil {
+		return iplist.Range{}, false
+	}
+
+	r, ok := ranges.lookup(ip)
+	if ok {
+		b.blocked.Add(1)
+	}
+	return r, ok
+}
+
+// NumRanges implements iplist.Ranger.
+func (b *blocklist) NumRanges() int {
+	ranges := b.ranges.Load()
+	if ranges == nil {
+		return 0
+	}
+	return len(ranges.v4) + len(ranges.v6)
+}
+
+func (b *blocklist) status() Blocklist {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := Blocklist{
+		Path:     b.path,
+		Ranges:   b.NumRanges(),
+		Blocked:  b.blocked.Load(),
+		LoadedAt: b.loadedAt,
+	}
+	if b.err != nil {
+		s.Error = b.err.Error()
+	}
+	return s
+}
+
+// reload loads the blocklist again if the file changed since the last time,
+// and reports whether it did.
+func (b *blocklist) reload() (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	info, err := os.Stat(b.path)
+	if err == nil && info.ModTime().Equal(b.modTime) {
+		return false, nil
+	}
+
+	var ranges blockRanges
+	if err == nil {
+		ranges, err = loadBlocklist(b.path)
+	}
+	if err != nil {
+		b.err = fmt.Errorf("load blocklist: %w", err)
+		return false, b.err
+	}
+
+	b.ranges.Store(&ranges)
+	b.modTime = info.ModTime()
+	b.loadedAt = time.Now()
+	b.err = nil
+	return true, nil
+}
+
+// reloadEvery picks up changes to the blocklist file.
+func (b *blocklist) reloadEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ok, err := b.reload()
+		if err != nil {
+			slog.Error("failed to reload blocklist", "path", b.path, "err", err)
+			continue
+		}
+		if ok {
+			slog.Info("reloaded blocklist", "path", b.path, "ranges", b.NumRanges())
+		}
+	}
+}
+
+func loadBlocklist(path string) (blockRanges, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return blockRanges{}, err
+	}
+	defer f.Close()
+
+	return parseBlocklist(f)
+}
+
+// parseBlocklist reads a blocklist in the eMule DAT or the PeerGuardian P2P
+// format, optionally gzipped. Lines that can't be parsed are skipped.
+func parseBlocklist(r io.Reader) (blockRanges, error) {
+	br := bufio.NewReader(r)
+
+	magic, _ := br.Peek(2)
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return blockRanges{}, err
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+
+	var ranges blockRanges
+	skipped := 0
+
+	scanner := bufio.NewScanner(br)
+	for scanner.Scan() {
+		r, ok, err := parseBlocklistLine(scanner.Text())
+		if err != nil {
+			skipped++
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		if len(r.First) == net.IPv4len {
+			ranges.v4 = append(ranges.v4, r)
+		} else {
+			ranges.v6 = append(ranges.v6, r)
+		}
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return blockRanges{}, err
+	}
+
+	if skipped > 0 {
+		slog.Warn("skipped malformed blocklist lines", "count", skipped)
+	}
+
+	ranges.v4 = mergeRanges(ranges.v4)
+	ranges.v6 = mergeRanges(ranges.v6)
+	return ranges, nil
+}
+
+// parseBlocklistLine parses a line like
+//
+//	001.009.096.105 - 001.009.096.105 , 000 , Some Org
+//
+// in the eMule DAT format, where ranges with an access level above 127 are
+// allowed, or a line like
+//
+//	Some Org:1.9.96.105-1.9.96.105
+//
+// in the P2P format. Blank lines and comments aren't ok but aren't errors
+// either.
+func parseBlocklistLine(line string) (r iplist.Range, ok bool, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+		return r, false, nil
+	}
+
+	// P2P descriptions may have commas too, so lines are only taken as DAT
+	// when they start with a range
+	fields := strings.Split(line, ",")
+	if len(fields) > 1 {
+		r, ok = parseBlocklistRange(fields[0])
+	}
+
+	if ok {
+		level, err := strconv.Atoi(strings.TrimSpace(fields[1]))
+		if err != nil {
+			return r, false, fmt.Errorf("access level %q", fields[1])
+		}
+		if level > 127 {
+			return r, false, nil
+		}
+
+		if len(fields) > 2 {
+			r.Description = strings.TrimSpace(strings.Join(fields[2:], ","))
+		}
+		return r, true, nil
+	}
+
+	colon := strings.LastIndexByte(line, ':')
+	if colon == -1 {
+		return r, false, errors.New("missing colon")
+	}
+
+	r, ok = parseBlocklistRange(line[colon+1:])
+	if !ok {
+		return r, false, fmt.Errorf("bad IP range %q", line[colon+1:])
+	}
+	r.Description = line[:colon]
+
+	return r, true, nil
+}
+
+// parseBlocklistRange parses a range like "1.2.3.0 - 1.2.3.255".
+func parseBlocklistRange(s string) (r iplist.Range, ok bool) {
+	first, last, found := strings.Cut(s, "-")
+	if !found {
+		return r, false
+	}
+
+	r.First, r.Last = parseBlocklistIP(first), parseBlocklistIP(last)
+	ok = r.First != nil && r.Last != nil && len(r.First) == len(r.Last) && bytes.Compare(r.First, r.Last) <= 0
+	return r, ok
+}
+
+// parseBlocklistIP parses an IP, allowing the zero padded octets of DAT
+// files. IPv4 addresses are returned in their 4 byte form.
+func parseBlocklistIP(s string) net.IP {
+	s = strings.TrimSpace(s)
+
+	if strings.Contains(s, ".") && !strings.Contains(s, ":") {
+		octets := strings.Split(s, ".")
+		for i, o := range octets {
+			octets[i] = strings.TrimLeft(o, "0")
+			if octets[i] == "" {
+				octets[i] = "0"
+			}
+		}
+		s = strings.Join(octets, ".")
+	}
+
+	ip := net.ParseIP(s)
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip
+}
+
+// mergeRanges sorts ranges and joins the ones that overlap.
+func mergeRanges(ranges []iplist.Range) []iplist.Range {
+	slices.SortFunc(ranges, func(a, b iplist.Range) int {
+		return bytes.Compare(a.First, b.First)
+	})
+
+	merged := ranges[:0]
+	for _, r := range ranges {
+		n := len(merged)
+		if n > 0 && bytes.Compare(r.First, merged[n-1].Last) <= 0 {
+			if bytes.Compare(r.Last, merged[n-1].Last) > 0 {
+				merged[n-1].Last = r.Last
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+func (b *blockRanges) lookup(ip net.IP) (iplist.Range, bool) {
+	ranges := b.v6
+	if v4 := ip.To4(); v4 != nil {
+		ranges, ip = b.v4, v4
+	} else {
+		ip = ip.To16()
+	}
+
+	i, _ := slices.BinarySearchFunc(ranges, ip, func(r iplist.Range, ip net.IP) int {
+		return bytes.Compare(r.Last, ip)
+	})
+	if i < len(ranges) && bytes.Compare(ranges[i].First, ip) <= 0 {
+		return ranges[i], true
+	}
+	return iplist.Range{}, false
+}
@@ -0,0 +1,71 @@
+package torrent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net"
+	"testing"
+)
+
+const testBlocklist = `# comment
+001.002.004.000 - 001.002.004.255 , 000 , Some Org
+001.002.008.000 - 001.002.008.255 , 200 , Allowed Org
+Other Org, Inc:10.0.0.0-10.0.0.255
+Overlapping:10.0.0.128-10.0.1.10
+not a range
+2001:db8::-2001:db8::ffff , 100 , IPv6 Org
+`
+
+func TestParseBlocklist(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(testBlocklist))
+	w.Close()
+
+	for name, data := range map[string][]byte{"plain": []byte(testBlocklist), "gzip": gz.Bytes()} {
+		ranges, err := parseBlocklist(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", name, err)
+		}
+
+		if len(ranges.v4) != 2 || len(ranges.v6) != 1 {
+			t.Fatalf("%s: expected 2 IPv4 and 1 IPv6 ranges, got %v and %v", name, ranges.v4, ranges.v6)
+		}
+
+		tests := []struct {
+			ip   string
+			want string
+		}{
+			{ip: "1.2.4.7", want: "Some Org"},
+			{ip: "1.2.8.7", want: ""},
+			{ip: "10.0.0.1", want: "Other Org, Inc"},
+			{ip: "10.0.1.10", want: "Other Org, Inc"},
+			{ip: "10.0.1.11", want: ""},
+			{ip: "::ffff:1.2.4.1", want: "Some Org"},
+			{ip: "2001:db8::1", want: "IPv6 Org"},
+			{ip: "2001:db9::1", want: ""},
+		}
+
+		for _, tt := range tests {
+			r, ok := ranges.lookup(net.ParseIP(tt.ip))
+			if ok != (tt.want != "") || r.Description != tt.want {
+				t.Errorf("%s: expected %s to be blocked by %q, got %q (%v)", name, tt.ip, tt.want, r.Description, ok)
+			}
+		}
+	}
+}
+
+func TestParseBlocklistLine(t *testing.T) {
+	for _, line := range []string{
+		"Some Org:1.2.3.4",
+		"Some Org:1.2.3.4-1.2.3",
+		"Some Org:1.2.3.9-1.2.3.4",
+		"1.2.3.4 - 1.2.3.5 , high , Some Org",
+		"not a range",
+	} {
+		_, _, err := parseBlocklistLine(line)
+		if err == nil {
+			t.Errorf("expected error for %q", line)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package torrent
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/types/infohash"
+)
+
+const (
+	TopicProgress = "torrent.progress"
+	TopicPeers    = "torrent.peers"
+	TopicState    = "torrent.state"
+)
+
+// States of a torrent, as published on TopicState.
+const (
+	StateMetadata    = "metadata"
+	StateIdle        = "idle"
+	StateDownloading = "downloading"
+	StateComplete    = "complete"
+	StateDropped     = "dropped"
+)
+
+// Progress is published on TopicProgress for every file being streamed or
+// downloaded.
+type Progress struct {
+	InfoHash string `json:"infoHash"`
+	FileIdx  int    `json:"fileIdx"`
+	Stat
+}
+
+// Peers is published on TopicPeers when the peers of a torrent change.
+type Peers struct {
+	InfoHash         string `json:"infoHash"`
+	TotalPeers       int    `json:"totalPeers"`
+	PendingPeers     int    `json:"pendingPeers"`
+	ActivePeers      int    `json:"activePeers"`
+	ConnectedSeeders int    `json:"connectedSeeders"`
+	HalfOpenPeers    int    `json:"halfOpenPeers"`
+}
+
+// State is published on TopicState when a torrent changes state.
+type State struct {
+	InfoHash string `json:"infoHash"`
+	Name     string `json:"name"`
+	State    string `json:"state"`
+}
+
+// PublishEvents publishes the progress, peers and state of every torrent to
+// h.Events each interval, until ctx is done.
+func (h *Service) PublishEvents(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	states := map[infohash.T]State{}
+	peers := map[infohash.T]Peers{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		active := h.activeFiles()
+		seen := map[infohash.T]bool{}
+
+		for _, t := range h.client.Torrents() {
+			ih := t.InfoHash()
+			if h.isProbing(ih) {
+				continue
+			}
+			seen[ih] = true
+
+			state := State{
+				InfoHash: ih.HexString(),
+				Name:     t.Name(),
+				State:    torrentState(t, active),
+			}
+			if states[ih] != state {
+				states[ih] = state
+				h.Events.Publish(TopicState, state)
+			}
+
+			stats := t.Stats()
+			p := Peers{
+				InfoHash:         ih.HexString(),
+				TotalPeers:       stats.TotalPeers,
+				PendingPeers:     stats.PendingPeers,
+				ActivePeers:      stats.ActivePeers,
+				ConnectedSeeders: stats.ConnectedSeeders,
+				HalfOpenPeers:    stats.HalfOpenPeers,
+			}
+			if peers[ih] != p {
+				peers[ih] = p
+				h.Events.Publish(TopicPeers, p)
+			}
+		}
+
+		for ih, state := range states {
+			if seen[ih] {
+				continue
+			}
+
+			state.State = StateDropped
+			h.Events.Publish(TopicState, state)
+			delete(states, ih)
+			delete(peers, ih)
+		}
+
+		for _, key := range active {
+			infoHash := key.infoHash.HexString()
+
+			stat, err := h.Stat(ctx, infoHash, key.fileIdx)
+			if err != nil {
+				continue
+			}
+
+			h.Events.Publish(TopicProgress, Progress{
+				InfoHash: infoHash,
+				FileIdx:  key.fileIdx,
+				Stat:     stat,
+			})
+		}
+	}
+}
+
+// activeFiles lists the files being streamed or downloaded.
+func (h *Service) activeFiles() []fileKey {
+	keys := h.readers.keys()
+
+	h.downloadsMu.Lock()
+	for key := range h.downloads {
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	h.downloadsMu.Unlock()
+
+	return keys
+}
+
+func torrentState(t *torrent.Torrent, active []fileKey) string {
+	if t.Info() == nil {
+		return StateMetadata
+	}
+
+	if t.BytesMissing() == 0 {
+		return StateComplete
+	}
+
+	for _, key := range active {
+		if key.infoHash == t.InfoHash() {
+			return StateDownloading
+		}
+	}
+
+	return StateIdle
+}
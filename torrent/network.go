@@ -0,0 +1,310 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/dht/v2"
+	alog "github.com/anacrolix/log"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/upnp"
+	"github.com/igorcafe/anyflix/config"
+	"github.com/igorcafe/anyflix/errorsx"
+)
+
+// upnpDescription names the port mappings on the router.
+const upnpDescription = "anyflix"
+
+// ClientStatus describes how the torrent client is connected.
+type ClientStatus struct {
+	ListenAddrs []string `json:"listenAddrs"`
+	// DHTNodes is how many good nodes the DHT servers know.
+	DHTNodes   int         `json:"dhtNodes"`
+	DHT        []DHTStatus `json:"dht"`
+	NAT        NATStatus   `json:"nat"`
+	Encryption string      `json:"encryption"`
+	Blocklist  Blocklist   `json:"blocklist"`
+}
+
+type DHTStatus struct {
+	Addr      string `json:"addr"`
+	GoodNodes int    `json:"goodNodes"`
+	Nodes     int    `json:"nodes"`
+}
+
+// NATStatus tells whether peers can reach the listen port through the router.
+type NATStatus struct {
+	PortForwarding bool `json:"portForwarding"`
+	// Pending is set while looking for UPnP devices.
+	Pending  bool          `json:"pending"`
+	Mappings []PortMapping `json:"mappings"`
+}
+
+// PortMapping is the result of asking a UPnP device to forward a port.
+type PortMapping struct {
+	Device       string `json:"device"`
+	Protocol     string `json:"protocol"`
+	ExternalIP   string `json:"externalIP,omitempty"`
+	InternalPort int    `json:"internalPort"`
+	ExternalPort int    `json:"externalPort,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// Status reports the listen addresses, DHT, NAT and blocklist status of the
+// client.
+func (h *Service) Status() ClientStatus {
+	status := ClientStatus{
+		ListenAddrs: []string{},
+		DHT:         []DHTStatus{},
+		Encryption:  h.network.Encryption,
+		Blocklist:   h.blocklist.status(),
+	}
+	if status.Encryption == "" {
+		status.Encryption = "prefer"
+	}
+
+	for _, addr := range h.client.ListenAddrs() {
+		status.ListenAddrs = append(status.ListenAddrs, addr.Network()+"://"+addr.String())
+	}
+
+	for _, s := range h.client.DhtServers() {
+		d := DHTStatus{Addr: s.Addr().String()}
+		if stats, ok := s.Stats().(dht.ServerStats); ok {
+			d.GoodNodes, d.Nodes = stats.GoodNodes, stats.Nodes
+		}
+		status.DHTNodes += d.GoodNodes
+		status.DHT = append(status.DHT, d)
+	}
+
+	h.nat.mu.Lock()
+	status.NAT = NATStatus{
+		PortForwarding: h.nat.enabled,
+		Pending:        h.nat.pending,
+		Mappings:       append([]PortMapping{}, h.nat.mappings...),
+	}
+	h.nat.mu.Unlock()
+
+	return status
+}
+
+// natState holds the port mappings made through UPnP. The client can map
+// ports by itself but doesn't report how it went, so it's done here instead.
+type natState struct {
+	mu       sync.Mutex
+	enabled  bool
+	pending  bool
+	mappings []PortMapping
+	// forwarded holds the mappings that worked, to be removed by
+	// UnforwardPort
+	forwarded []natMapping
+	// closed is set by UnforwardPort, for mappings made after it to be
+	// removed right away
+	closed bool
+}
+
+type natMapping struct {
+	device   upnp.Device
+	proto    upnp.Protocol
+	external int
+}
+
+// portUnmapper is implemented by the devices upnp.Discover returns, though
+// upnp.Device lacks the method.
+type portUnmapper interface {
+	DeletePortMapping(protocol upnp.Protocol, externalPort int) error
+}
+
+// forwardPort asks every UPnP device in the network to forward the listen
+// port of the client.
+func (h *Service) forwardPort() {
+	port := h.client.LocalPort()
+	devices := upnp.Discover(0, 2*time.Second, alog.Default)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	mappings := []PortMapping{}
+	forwarded := []natMapping{}
+
+	for _, d := range devices {
+		for _, proto := range []upnp.Protocol{upnp.TCP, upnp.UDP} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				m := mapPort(d, proto, port)
+
+				mu.Lock()
+				mappings = append(mappings, m)
+				if m.Error == "" {
+					forwarded = append(forwarded, natMapping{d, proto, m.ExternalPort})
+				}
+				mu.Unlock()
+			}()
+		}
+	}
+	wg.Wait()
+
+	slog.Info("forwarded port through UPnP", "port", port, "devices", len(devices))
+
+	h.nat.mu.Lock()
+	h.nat.pending = false
+	h.nat.mappings = mappings
+	closed := h.nat.closed
+	if !closed {
+		h.nat.forwarded = forwarded
+	}
+	h.nat.mu.Unlock()
+
+	if closed {
+		_ = unmapPorts(forwarded)
+	}
+}
+
+// UnforwardPort removes the port mappings made through UPnP, which would
+// otherwise stay on the devices as they're made without a lease. Mappings
+// still being made are removed once done.
+func (h *Service) UnforwardPort() error {
+	h.nat.mu.Lock()
+	forwarded := h.nat.forwarded
+	h.nat.forwarded = nil
+	h.nat.closed = true
+	h.nat.mu.Unlock()
+
+	return unmapPorts(forwarded)
+}
+
+func unmapPorts(forwarded []natMapping) error {
+	var errs []error
+	for _, m := range forwarded {
+		d, ok := m.device.(portUnmapper)
+		if !ok {
+			continue
+		}
+
+		err := d.DeletePortMapping(m.proto, m.external)
+		if err != nil {
+			slog.Warn("failed to remove port mapping", "device", m.device.GetLocalIPAddress(), "protocol", m.proto, "err", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func mapPort(d upnp.Device, proto upnp.Protocol, port int) PortMapping {
+	m := PortMapping{
+		Device:       d.GetLocalIPAddress().String(),
+		Protocol:     string(proto),
+		InternalPort: port,
+	}
+
+	external, err := d.AddPortMapping(proto, port, port, upnpDescription, 0)
+	if err != nil {
+		slog.Warn("failed to forward port", "device", m.Device, "protocol", proto, "err", err)
+		m.Error = err.Error()
+		return m
+	}
+	m.ExternalPort = external
+
+	ip, err := d.GetExternalIPAddress()
+	if err == nil {
+		m.ExternalIP = ip.String()
+	}
+
+	return m
+}
+
+// configureNetwork applies the network config to the client config. It fails
+// with errorsx.Invalid for malformed settings.
+func configureNetwork(cc *torrent.ClientConfig, cfg config.NetworkConfig) error {
+	if cfg.ListenPort < 0 || cfg.ListenPort > 65535 {
+		return fmt.Errorf("%w listen port %d", errorsx.Invalid, cfg.ListenPort)
+	}
+	cc.ListenPort = cfg.ListenPort
+
+	// port forwarding is done by Service.forwardPort
+	cc.NoDefaultPortForwarding = true
+	cc.DisableIPv6 = cfg.DisableIPv6
+
+	switch cfg.Encryption {
+	case "", "prefer":
+		cc.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{Preferred: true}
+	case "require":
+		cc.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{Preferred: true, RequirePreferred: true}
+	case "disable":
+		cc.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{}
+	default:
+		return fmt.Errorf("%w encryption %q, expected prefer, require or disable", errorsx.Invalid, cfg.Encryption)
+	}
+
+	if cfg.ListenHost == "" {
+		return nil
+	}
+
+	ip4, ip6, err := listenIPs(cfg.ListenHost)
+	if err != nil {
+		return err
+	}
+
+	// an empty host would listen on every interface, so families the host
+	// doesn't have are disabled instead
+	cc.DisableIPv4 = ip4 == ""
+	cc.DisableIPv6 = cc.DisableIPv6 || ip6 == ""
+	if cc.DisableIPv4 && cc.DisableIPv6 {
+		return fmt.Errorf("%w listen host %q: IPv6 is disabled and it has no IPv4 address", errorsx.Invalid, cfg.ListenHost)
+	}
+
+	cc.ListenHost = func(network string) string {
+		if strings.HasSuffix(network, "6") {
+			return ip6
+		}
+		return ip4
+	}
+
+	return nil
+}
+
+// listenIPs resolves an IP or the name of a network interface to the IPv4
+// and IPv6 addresses to listen on, either of which may be empty.
+func listenIPs(host string) (ip4, ip6 string, err error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() != nil {
+			return ip.String(), "", nil
+		}
+		return "", ip.String(), nil
+	}
+
+	iface, err := net.InterfaceByName(host)
+	if err != nil {
+		return "", "", fmt.Errorf("%w listen host %q: %w", errorsx.Invalid, host, err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", "", fmt.Errorf("addresses of %s: %w", host, err)
+	}
+
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+
+		if ipnet.IP.To4() != nil {
+			if ip4 == "" {
+				ip4 = ipnet.IP.String()
+			}
+		} else if ip6 == "" {
+			ip6 = ipnet.IP.String()
+		}
+	}
+
+	if ip4 == "" && ip6 == "" {
+		return "", "", fmt.Errorf("%w listen host %q: interface has no addresses", errorsx.Invalid, host)
+	}
+
+	return ip4, ip6, nil
+}
@@ -0,0 +1,42 @@
+package torrent
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	"github.com/igorcafe/anyflix/config"
+	"github.com/igorcafe/anyflix/errorsx"
+)
+
+func TestConfigureNetwork(t *testing.T) {
+	invalid := []config.NetworkConfig{
+		{ListenPort: -1},
+		{ListenPort: 70000},
+		{Encryption: "always"},
+		{ListenHost: "no-such-interface0"},
+		{ListenHost: "::1", DisableIPv6: true},
+	}
+
+	for _, cfg := range invalid {
+		err := configureNetwork(torrent.NewDefaultClientConfig(), cfg)
+		if !errors.Is(err, errorsx.Invalid) {
+			t.Errorf("expected invalid error for %+v, got %v", cfg, err)
+		}
+	}
+
+	cc := torrent.NewDefaultClientConfig()
+	err := configureNetwork(cc, config.NetworkConfig{ListenPort: 6881, ListenHost: "127.0.0.1", Encryption: "require"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if cc.ListenPort != 6881 || !cc.DisableIPv6 || cc.DisableIPv4 || cc.ListenHost("tcp4") != "127.0.0.1" {
+		t.Errorf("expected to listen on 127.0.0.1:6881 only, got port %d, host %q, ipv4 disabled %v, ipv6 disabled %v",
+			cc.ListenPort, cc.ListenHost("tcp4"), cc.DisableIPv4, cc.DisableIPv6)
+	}
+
+	if !cc.HeaderObfuscationPolicy.RequirePreferred || !cc.NoDefaultPortForwarding {
+		t.Errorf("expected required encryption and no default port forwarding, got %+v and %v", cc.HeaderObfuscationPolicy, cc.NoDefaultPortForwarding)
+	}
+}
@@ -0,0 +1,122 @@
+package torrent
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/types/infohash"
+)
+
+const (
+	// rateWindow is how far back transfer rates look.
+	rateWindow = 10 * time.Second
+
+	// minBuffer is how much video must be buffered ahead of the playhead
+	// before playback is considered smooth, even on fast swarms.
+	minBuffer = 5 * time.Second
+)
+
+type rateSample struct {
+	at      time.Time
+	read    int64
+	written int64
+}
+
+// rateMeter samples the transfer counters of every torrent to compute rates
+// over a sliding window, so they don't depend on how often stats are polled.
+type rateMeter struct {
+	mu      sync.Mutex
+	samples map[infohash.T][]rateSample
+}
+
+func newRateMeter() *rateMeter {
+	return &rateMeter{
+		samples: map[infohash.T][]rateSample{},
+	}
+}
+
+func (m *rateMeter) sample(now time.Time, torrents []*torrent.Torrent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen := map[infohash.T]bool{}
+
+	for _, t := range torrents {
+		ih := t.InfoHash()
+		seen[ih] = true
+
+		stats := t.Stats()
+		samples := append(m.samples[ih], rateSample{
+			at:      now,
+			read:    stats.BytesReadUsefulData.Int64(),
+			written: stats.BytesWrittenData.Int64(),
+		})
+
+		// keep one sample older than the window, so the window is always
+		// fully covered
+		for len(samples) > 2 && now.Sub(samples[1].at) >= rateWindow {
+			samples = samples[1:]
+		}
+		m.samples[ih] = samples
+	}
+
+	for ih := range m.samples {
+		if !seen[ih] {
+			delete(m.samples, ih)
+		}
+	}
+}
+
+func (m *rateMeter) sampleEvery(client *torrent.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		m.sample(now, client.Torrents())
+	}
+}
+
+// rates returns the download and upload rates of a torrent in bytes per
+// second.
+func (m *rateMeter) rates(ih infohash.T) (down, up float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	samples := m.samples[ih]
+	if len(samples) < 2 {
+		return 0, 0
+	}
+
+	first, last := samples[0], samples[len(samples)-1]
+	secs := last.at.Sub(first.at).Seconds()
+
+	return float64(last.read-first.read) / secs, float64(last.written-first.written) / secs
+}
+
+// readyIn estimates how many seconds to wait before playing from the
+// playhead won't stall, given the download rate and the bitrate in bytes per
+// second, the bytes buffered ahead of the playhead and the bytes left to
+// play. It returns -1 when there's no way to tell.
+func readyIn(rate float64, bitrate, buffered, remaining int64) float64 {
+	if buffered >= remaining {
+		return 0
+	}
+	if rate <= 0 || bitrate <= 0 {
+		return -1
+	}
+
+	// playback needs a small buffer to start
+	wait := (float64(bitrate)*minBuffer.Seconds() - float64(buffered)) / rate
+
+	// and, when the swarm is slower than the video, enough of a head start
+	// for the download to finish before the playhead catches up
+	if rate < float64(bitrate) {
+		download := float64(remaining-buffered) / rate
+		play := float64(remaining) / float64(bitrate)
+		wait = max(wait, download-play)
+	}
+
+	return math.Ceil(max(wait, 0))
+}
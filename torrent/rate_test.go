@@ -0,0 +1,31 @@
+package torrent
+
+import "testing"
+
+func TestReadyIn(t *testing.T) {
+	tests := []struct {
+		name      string
+		rate      float64
+		bitrate   int64
+		buffered  int64
+		remaining int64
+		want      float64
+	}{
+		{name: "complete", rate: 0, bitrate: 0, buffered: 100, remaining: 100, want: 0},
+		{name: "stalled", rate: 0, bitrate: 1e6, buffered: 0, remaining: 100e6, want: -1},
+		{name: "unknown bitrate", rate: 1e6, bitrate: 0, buffered: 0, remaining: 100e6, want: -1},
+		{name: "fast swarm", rate: 4e6, bitrate: 1e6, buffered: 0, remaining: 1e9, want: 2},
+		{name: "fast swarm buffered", rate: 4e6, bitrate: 1e6, buffered: 10e6, remaining: 1e9, want: 0},
+		{name: "slow swarm", rate: 0.5e6, bitrate: 1e6, buffered: 0, remaining: 100e6, want: 100},
+		{name: "slow swarm buffered", rate: 0.5e6, bitrate: 1e6, buffered: 50e6, remaining: 100e6, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := readyIn(tt.rate, tt.bitrate, tt.buffered, tt.remaining)
+			if got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
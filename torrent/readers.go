@@ -0,0 +1,434 @@
+package torrent
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/types"
+	"github.com/anacrolix/torrent/types/infohash"
+	"github.com/igorcafe/anyflix/probe"
+)
+
+const (
+	// readahead bounds, defaultReadahead is used until the bitrate of a file
+	// is known
+	minReadahead     = 8 << 20
+	maxReadahead     = 256 << 20
+	defaultReadahead = 32 << 20
+
+	// tailSize is how much of the end of a file is fetched with high
+	// priority, since players read the MKV cues or the MP4 moov from there
+	// before starting playback.
+	tailSize = 4 << 20
+
+	// maxFileReaders is how many idle readers are kept for a file. Players
+	// usually keep one connection at the playhead and open another one to
+	// peek at the end of the file.
+	maxFileReaders = 4
+
+	readerIdleTimeout = time.Minute
+)
+
+type fileKey struct {
+	infoHash infohash.T
+	fileIdx  int
+}
+
+// readerPool keeps the readers of the files being streamed alive across
+// range requests, so the pieces around each playhead keep their priority
+// instead of starting over on every request.
+type readerPool struct {
+	mu    sync.Mutex
+	files map[fileKey]*streamFile
+}
+
+type streamFile struct {
+	file *torrent.File
+
+	// bitrate in bytes per second, zero while unknown
+	bitrate atomic.Int64
+	// readahead is read by the client while it's locked, so it must not
+	// take any locks
+	readahead atomic.Int64
+
+	readers []*streamReader
+}
+
+// streamReader is a reader of a streamFile. It's used by one request at a
+// time.
+type streamReader struct {
+	torrent.Reader
+	file *streamFile
+
+	pos      atomic.Int64
+	inUse    bool
+	lastUsed time.Time
+}
+
+func newReaderPool() *readerPool {
+	return &readerPool{
+		files: map[fileKey]*streamFile{},
+	}
+}
+
+// acquire returns an idle reader of the file close enough to pos for its
+// readahead to cover it, or a new one. created reports whether the file
+// wasn't being streamed yet.
+func (p *readerPool) acquire(key fileKey, file *torrent.File, pos int64) (r *streamReader, created bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sf, ok := p.files[key]
+	if !ok {
+		sf = &streamFile{file: file}
+		sf.readahead.Store(defaultReadahead)
+		setTailPriority(file, torrent.PiecePriorityHigh)
+		p.files[key] = sf
+		created = true
+	}
+
+	window := sf.readahead.Load()
+	for _, candidate := range sf.readers {
+		if candidate.inUse {
+			continue
+		}
+
+		dist := pos - candidate.pos.Load()
+		if dist < 0 || dist > window {
+			continue
+		}
+
+		if r == nil || dist < pos-r.pos.Load() {
+			r = candidate
+		}
+	}
+
+	if r == nil {
+		r = &streamReader{
+			Reader: file.NewReader(),
+			file:   sf,
+		}
+		r.SetReadaheadFunc(func(torrent.ReadaheadContext) int64 {
+			return sf.readahead.Load()
+		})
+		sf.readers = append(sf.readers, r)
+	}
+
+	r.inUse = true
+	r.lastUsed = time.Now()
+	return r, created
+}
+
+// release returns r to the pool, closing the least recently used idle
+// readers of its file past maxFileReaders.
+func (p *readerPool) release(r *streamReader) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	r.inUse = false
+	r.lastUsed = time.Now()
+
+	sf := r.file
+	if len(sf.readers) <= maxFileReaders {
+		return
+	}
+
+	slices.SortFunc(sf.readers, func(a, b *streamReader) int {
+		return a.lastUsed.Compare(b.lastUsed)
+	})
+
+	excess := len(sf.readers) - maxFileReaders
+	sf.readers = slices.DeleteFunc(sf.readers, func(r *streamReader) bool {
+		if excess == 0 || r.inUse {
+			return false
+		}
+		excess--
+		r.Close()
+		return true
+	})
+}
+
+// prune closes readers idle since before deadline, forgetting files left
+// without readers.
+func (p *readerPool) prune(deadline time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, sf := range p.files {
+		sf.readers = slices.DeleteFunc(sf.readers, func(r *streamReader) bool {
+			if r.inUse || r.lastUsed.After(deadline) {
+				return false
+			}
+			r.Close()
+			return true
+		})
+
+		if len(sf.readers) == 0 {
+			setTailPriority(sf.file, torrent.PiecePriorityNone)
+			delete(p.files, key)
+		}
+	}
+}
+
+// forget closes every reader of a torrent, which must be done when it's
+// dropped so a torrent added again with the same infohash gets new ones.
+func (p *readerPool) forget(ih infohash.T) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, sf := range p.files {
+		if key.infoHash != ih {
+			continue
+		}
+
+		for _, r := range sf.readers {
+			r.Close()
+		}
+		delete(p.files, key)
+	}
+}
+
+func (p *readerPool) pruneEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		p.prune(now.Add(-readerIdleTimeout))
+	}
+}
+
+// setBitrate updates the bitrate of a file being streamed and the readahead
+// of its readers.
+func (p *readerPool) setBitrate(key fileKey, bitrate int64, readahead time.Duration) {
+	p.mu.Lock()
+	sf, ok := p.files[key]
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	sf.bitrate.Store(bitrate)
+	sf.readahead.Store(min(max(bitrate*int64(readahead/time.Second), minReadahead), maxReadahead))
+}
+
+// snapshot returns a file being streamed and the position of its readers,
+// or nil.
+func (p *readerPool) snapshot(key fileKey) (*streamFile, []BufferReader) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sf, ok := p.files[key]
+	if !ok {
+		return nil, nil
+	}
+
+	readers := []BufferReader{}
+	for _, r := range sf.readers {
+		readers = append(readers, BufferReader{
+			Position: r.pos.Load(),
+			Active:   r.inUse,
+		})
+	}
+
+	return sf, readers
+}
+
+// keys lists the files being streamed.
+func (p *readerPool) keys() []fileKey {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keys := []fileKey{}
+	for key := range p.files {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// playhead returns the position of the reader of a file that was used last,
+// preferring the ones in use.
+func (p *readerPool) playhead(key fileKey) (*streamFile, int64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sf, ok := p.files[key]
+	if !ok || len(sf.readers) == 0 {
+		return nil, 0, false
+	}
+
+	last := sf.readers[0]
+	for _, r := range sf.readers[1:] {
+		if r.inUse != last.inUse {
+			if r.inUse {
+				last = r
+			}
+			continue
+		}
+		if r.lastUsed.After(last.lastUsed) {
+			last = r
+		}
+	}
+
+	return sf, last.pos.Load(), true
+}
+
+// bind returns an io.Reader reading from r until ctx is done.
+func (r *streamReader) bind(ctx context.Context) boundReader {
+	return boundReader{r, ctx}
+}
+
+type boundReader struct {
+	r   *streamReader
+	ctx context.Context
+}
+
+func (b boundReader) Read(p []byte) (int, error) {
+	n, err := b.r.ReadContext(b.ctx, p)
+	b.r.pos.Add(int64(n))
+	return n, err
+}
+
+// setTailPriority sets the priority of the last pieces of file.
+func setTailPriority(file *torrent.File, prio types.PiecePriority) {
+	t := file.Torrent()
+	pieceLen := t.Info().PieceLength
+
+	tailStart := file.Offset() + max(file.Length()-tailSize, 0)
+	for i := int(tailStart / pieceLen); i < file.EndPieceIndex(); i++ {
+		t.Piece(i).SetPriority(prio)
+	}
+}
+
+// contiguous counts the bytes of file that are complete from pos on, without
+// gaps.
+func contiguous(file *torrent.File, pos int64) int64 {
+	t := file.Torrent()
+	pieceLen := t.Info().PieceLength
+
+	start := file.Offset() + pos
+	end := file.Offset() + file.Length()
+
+	off := start
+	for i := int(start / pieceLen); i < file.EndPieceIndex(); i++ {
+		if !t.PieceState(i).Complete {
+			break
+		}
+		off = min(int64(i+1)*pieceLen, end)
+	}
+
+	return off - start
+}
+
+// streamReader returns a pooled reader of the file positioned at pos. The
+// bitrate of files that weren't being streamed is estimated in the
+// background, to size their readahead.
+func (h *Service) streamReader(file *torrent.File, fileIdx int, pos int64) (*streamReader, error) {
+	key := fileKey{file.Torrent().InfoHash(), fileIdx}
+
+	r, created := h.readers.acquire(key, file, pos)
+	if created {
+		// resumes the torrent if it was paused as a background download
+		h.applyBandwidth(time.Now())
+		go h.estimateBitrate(key, file)
+	}
+
+	_, err := r.Seek(pos, io.SeekStart)
+	if err != nil {
+		h.readers.release(r)
+		return nil, err
+	}
+	r.pos.Store(pos)
+
+	return r, nil
+}
+
+// estimateBitrate probes the duration of a file to work out its average
+// bitrate. It gives up once the torrent is dropped, rather than adding it
+// again.
+func (h *Service) estimateBitrate(key fileKey, file *torrent.File) {
+	infoHash := key.infoHash.HexString()
+
+	t, err := h.existing(infoHash)
+	if err != nil || t != file.Torrent() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// reads would wait on the dropped torrent until the timeout otherwise
+	go func() {
+		select {
+		case <-t.Closed():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	reader := newHeaderReader(ctx, file)
+	defer reader.Close()
+
+	info, err := probe.Probe(reader, file.Length())
+	if err != nil || info.Duration <= 0 {
+		slog.Debug("failed to estimate bitrate", "infoHash", infoHash, "fileIdx", key.fileIdx, "err", err)
+		return
+	}
+
+	bitrate := int64(float64(file.Length()) / info.Duration)
+	h.readers.setBitrate(key, bitrate, h.Readahead)
+	slog.Debug("estimated bitrate", "infoHash", infoHash, "fileIdx", key.fileIdx, "bitrate", bitrate)
+}
+
+// Buffer describes the readers streaming a file.
+type Buffer struct {
+	// Bitrate is the average bitrate of the file in bytes per second, zero
+	// while unknown.
+	Bitrate   int64          `json:"bitrate"`
+	Readahead int64          `json:"readahead"`
+	Readers   []BufferReader `json:"readers"`
+}
+
+type BufferReader struct {
+	Position int64 `json:"position"`
+	// Buffered is how many bytes from Position on are already complete.
+	Buffered int64 `json:"buffered"`
+	Active   bool  `json:"active"`
+}
+
+// Buffer reports the state of the readers of a file. A file that isn't being
+// streamed has no readers.
+func (h *Service) Buffer(ctx context.Context, infoHash string, fileIdx int) (Buffer, error) {
+	t, err := h.existing(infoHash)
+	if err != nil {
+		return Buffer{}, err
+	}
+
+	buf := Buffer{
+		Readahead: defaultReadahead,
+		Readers:   []BufferReader{},
+	}
+
+	sf, readers := h.readers.snapshot(fileKey{t.InfoHash(), fileIdx})
+	if sf == nil {
+		if t.Info() != nil {
+			err = checkFileIdx(t, fileIdx)
+		}
+		return buf, err
+	}
+
+	buf.Bitrate = sf.bitrate.Load()
+	buf.Readahead = sf.readahead.Load()
+
+	for _, r := range readers {
+		r.Buffered = contiguous(sf.file, r.Position)
+		buf.Readers = append(buf.Readers, r)
+	}
+
+	return buf, nil
+}
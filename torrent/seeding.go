@@ -0,0 +1,293 @@
+package torrent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/types/infohash"
+	"github.com/igorcafe/anyflix/config"
+	"github.com/igorcafe/anyflix/errorsx"
+)
+
+// seedState tracks a torrent once nothing is being downloaded from it.
+type seedState struct {
+	since time.Time
+	// stopped is why seeding was stopped, empty while seeding
+	stopped   string
+	stoppedAt time.Time
+}
+
+func (s *seedState) seeded(now time.Time) time.Duration {
+	if s.stopped != "" {
+		return s.stoppedAt.Sub(s.since)
+	}
+	return now.Sub(s.since)
+}
+
+// EnforceSeeding stops seeding the torrents that reached the limits of
+// their policy, checking each interval until ctx is done. Torrents being
+// downloaded from again seed again.
+func (h *Service) EnforceSeeding(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.enforceSeeding(now)
+		}
+	}
+}
+
+func (h *Service) enforceSeeding(now time.Time) {
+	active := h.activeFiles()
+	policies := h.torrentPolicies()
+
+	h.seedMu.Lock()
+	defer h.seedMu.Unlock()
+
+	seen := map[infohash.T]bool{}
+
+	for _, t := range h.client.Torrents() {
+		ih := t.InfoHash()
+		if h.isProbing(ih) || t.Info() == nil {
+			continue
+		}
+		seen[ih] = true
+
+		st, ok := h.seeds[ih]
+
+		if torrentState(t, active) == StateDownloading {
+			if ok && st.stopped != "" {
+				t.AllowDataUpload()
+			}
+			delete(h.seeds, ih)
+			if past, ok := h.past[ih]; ok {
+				past.Seeded = 0
+				h.past[ih] = past
+			}
+			continue
+		}
+
+		if !ok {
+			st = &seedState{since: now.Add(-h.past[ih].Seeded)}
+			h.seeds[ih] = st
+		}
+
+		reason := seedingStop(h.policy(ih, policies), h.ratio(t), st.seeded(now), h.requested[ih])
+
+		switch {
+		case reason != "" && st.stopped == "":
+			t.DisallowDataUpload()
+			st.stopped = reason
+			st.stoppedAt = now
+			slog.Info("stopped seeding", "infoHash", ih.HexString(), "reason", reason)
+
+		case reason == "" && st.stopped != "":
+			// the policy changed
+			t.AllowDataUpload()
+			st.stopped = ""
+			slog.Info("resumed seeding", "infoHash", ih.HexString())
+		}
+	}
+
+	for ih := range h.seeds {
+		if !seen[ih] {
+			delete(h.seeds, ih)
+		}
+	}
+}
+
+// GlobalSeeding returns the policy of torrents without one of their own.
+func (h *Service) GlobalSeeding() config.SeedingPolicy {
+	h.seedMu.Lock()
+	defer h.seedMu.Unlock()
+	return h.seeding
+}
+
+// SetGlobalSeeding replaces the policy of torrents without one of their own.
+// It fails with errorsx.Invalid for negative limits.
+func (h *Service) SetGlobalSeeding(p config.SeedingPolicy) error {
+	err := ValidateSeeding(p)
+	if err != nil {
+		return err
+	}
+
+	h.seedMu.Lock()
+	h.seeding = p
+	h.seedMu.Unlock()
+	return nil
+}
+
+func ValidateSeeding(p config.SeedingPolicy) error {
+	if p.Ratio < 0 || p.Hours < 0 {
+		return fmt.Errorf("%w negative seeding limit", errorsx.Invalid)
+	}
+	return nil
+}
+
+// torrentPolicies loads the policies of the torrents that have one of their
+// own. Failures are logged and leave every torrent on the global policy.
+func (h *Service) torrentPolicies() map[string]config.SeedingPolicy {
+	if h.SeedingPolicies == nil {
+		return nil
+	}
+
+	policies, err := h.SeedingPolicies()
+	if err != nil {
+		slog.Error("failed to load seeding policies", "err", err)
+	}
+	return policies
+}
+
+// policy returns the seeding policy of a torrent, given the ones loaded by
+// torrentPolicies. Must be called with h.seedMu held.
+func (h *Service) policy(ih infohash.T, policies map[string]config.SeedingPolicy) config.SeedingPolicy {
+	if p, ok := policies[ih.HexString()]; ok {
+		return p
+	}
+	return h.seeding
+}
+
+// seedingStop tells why a torrent must stop seeding, or returns "" when it
+// can keep going.
+func seedingStop(p config.SeedingPolicy, ratio float64, seeded time.Duration, downloaded bool) string {
+	switch {
+	case !p.Streamed && !downloaded:
+		return "streamed only"
+	case p.Ratio > 0 && ratio >= p.Ratio:
+		return "ratio reached"
+	case p.Hours > 0 && seeded.Hours() >= p.Hours:
+		return "seeding time reached"
+	default:
+		return ""
+	}
+}
+
+// ratio divides the bytes uploaded, including in earlier runs, by the bytes
+// the torrent has, counting data that was already on disk as downloaded.
+// Must be called with h.seedMu held.
+func (h *Service) ratio(t *torrent.Torrent) float64 {
+	stats := t.Stats()
+
+	base := stats.BytesReadUsefulData.Int64()
+	if t.Info() != nil {
+		base = max(base, t.BytesCompleted())
+	}
+	if base == 0 {
+		return 0
+	}
+
+	uploaded := h.past[t.InfoHash()].Uploaded + stats.BytesWrittenData.Int64()
+	return float64(uploaded) / float64(base)
+}
+
+// SeedingState is the seeding progress of a torrent, which the limits of
+// its policy are checked against.
+type SeedingState struct {
+	InfoHash string
+	Uploaded int64
+	// Seeded is how long the torrent was seeded since it was last
+	// downloaded from.
+	Seeded time.Duration
+	// Requested is set for torrents downloaded through DownloadFile.
+	Requested bool
+}
+
+// SeedingStates lists the seeding progress of the torrents in the client and
+// of the ones dropped or restored since, so it can be restored after a
+// restart with RestoreSeeding.
+func (h *Service) SeedingStates() []SeedingState {
+	now := time.Now()
+
+	h.seedMu.Lock()
+	defer h.seedMu.Unlock()
+
+	states := map[infohash.T]SeedingState{}
+	for ih, past := range h.past {
+		states[ih] = past
+	}
+
+	for _, t := range h.client.Torrents() {
+		ih := t.InfoHash()
+		if h.isProbing(ih) {
+			continue
+		}
+
+		s := h.past[ih]
+		s.InfoHash = ih.HexString()
+		stats := t.Stats()
+		s.Uploaded += stats.BytesWrittenData.Int64()
+		if st, ok := h.seeds[ih]; ok {
+			s.Seeded = st.seeded(now)
+		}
+		s.Requested = h.requested[ih]
+		states[ih] = s
+	}
+
+	list := []SeedingState{}
+	for _, s := range states {
+		list = append(list, s)
+	}
+	return list
+}
+
+// RestoreSeeding picks up the seeding progress saved from SeedingStates, so
+// the limits of the policies don't reset on restarts.
+func (h *Service) RestoreSeeding(states []SeedingState) {
+	h.seedMu.Lock()
+	defer h.seedMu.Unlock()
+
+	for _, s := range states {
+		ih, err := ParseInfoHash(s.InfoHash)
+		if err != nil {
+			slog.Warn("ignoring seeding state", "infoHash", s.InfoHash, "err", err)
+			continue
+		}
+
+		s.InfoHash = ih.HexString()
+		h.past[ih] = s
+		if s.Requested {
+			h.requested[ih] = true
+		}
+	}
+}
+
+// keepSeeding moves the seeding progress of a torrent about to be dropped to
+// h.past, so it goes on if the torrent is added again.
+func (h *Service) keepSeeding(t *torrent.Torrent, now time.Time) {
+	ih := t.InfoHash()
+	if h.isProbing(ih) {
+		return
+	}
+
+	h.seedMu.Lock()
+	defer h.seedMu.Unlock()
+
+	past := h.past[ih]
+	past.InfoHash = ih.HexString()
+	stats := t.Stats()
+	past.Uploaded += stats.BytesWrittenData.Int64()
+	past.Requested = h.requested[ih]
+	if st, ok := h.seeds[ih]; ok {
+		past.Seeded = st.seeded(now)
+		delete(h.seeds, ih)
+	}
+	h.past[ih] = past
+}
+
+// forgetSeeding drops the seeding progress of a torrent whose data was
+// deleted.
+func (h *Service) forgetSeeding(ih infohash.T) {
+	h.seedMu.Lock()
+	defer h.seedMu.Unlock()
+
+	delete(h.past, ih)
+	delete(h.requested, ih)
+	delete(h.seeds, ih)
+}
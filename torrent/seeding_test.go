@@ -0,0 +1,34 @@
+package torrent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/igorcafe/anyflix/config"
+)
+
+func TestSeedingStop(t *testing.T) {
+	tests := []struct {
+		policy     config.SeedingPolicy
+		ratio      float64
+		seeded     time.Duration
+		downloaded bool
+		want       string
+	}{
+		{policy: config.SeedingPolicy{Streamed: true}, ratio: 10, seeded: 100 * time.Hour, want: ""},
+		{policy: config.SeedingPolicy{}, want: "streamed only"},
+		{policy: config.SeedingPolicy{}, downloaded: true, want: ""},
+		{policy: config.SeedingPolicy{Ratio: 2, Streamed: true}, ratio: 1.5, want: ""},
+		{policy: config.SeedingPolicy{Ratio: 2, Streamed: true}, ratio: 2, want: "ratio reached"},
+		{policy: config.SeedingPolicy{Hours: 1.5}, seeded: time.Hour, downloaded: true, want: ""},
+		{policy: config.SeedingPolicy{Hours: 1.5}, seeded: 2 * time.Hour, downloaded: true, want: "seeding time reached"},
+	}
+
+	for _, tt := range tests {
+		got := seedingStop(tt.policy, tt.ratio, tt.seeded, tt.downloaded)
+		if got != tt.want {
+			t.Errorf("%+v ratio=%v seeded=%v downloaded=%v: expected %q, got %q",
+				tt.policy, tt.ratio, tt.seeded, tt.downloaded, tt.want, got)
+		}
+	}
+}
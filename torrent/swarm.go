@@ -0,0 +1,97 @@
+package torrent
+
+import (
+	"context"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/types/infohash"
+)
+
+// Swarm describes the peers of a torrent.
+type Swarm struct {
+	// Peers is how many peers were found through DHT, trackers and PEX.
+	Peers     int  `json:"peers"`
+	Connected int  `json:"connected"`
+	Seeders   int  `json:"seeders"`
+	Metadata  bool `json:"metadata"`
+}
+
+// ProbeSwarm looks for the peers of a torrent for window and reports what it
+// found. Torrents that weren't added yet are added without downloading any
+// data, and dropped afterwards unless something else uses them meanwhile.
+// trackers are only announced to for the probe.
+func (h *Service) ProbeSwarm(ctx context.Context, infoHash string, trackers []string, window time.Duration) (Swarm, error) {
+	ih, err := ParseInfoHash(infoHash)
+	if err != nil {
+		return Swarm{}, err
+	}
+
+	h.probingMu.Lock()
+	t, ok := h.client.Torrent(ih)
+	if !ok {
+		t, _, err = h.client.AddTorrentSpec(&torrent.TorrentSpec{
+			InfoHash:             ih,
+			Trackers:             h.trackerTiers(ih, trackers...),
+			DisallowDataDownload: true,
+		})
+		if err != nil {
+			h.probingMu.Unlock()
+			return Swarm{}, err
+		}
+		h.probing[ih] = true
+	}
+	h.probingMu.Unlock()
+
+	if ok {
+		return swarm(t), nil
+	}
+
+	timer := time.NewTimer(window)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	s := swarm(t)
+
+	h.probingMu.Lock()
+	if h.probing[ih] {
+		delete(h.probing, ih)
+		t.Drop()
+	}
+	h.probingMu.Unlock()
+
+	return s, ctx.Err()
+}
+
+// unprobe lets a torrent added by ProbeSwarm download data and stay around,
+// once something else wants it.
+func (h *Service) unprobe(ih infohash.T, t *torrent.Torrent) {
+	h.probingMu.Lock()
+	defer h.probingMu.Unlock()
+
+	if h.probing[ih] {
+		delete(h.probing, ih)
+		t.AllowDataDownload()
+	}
+}
+
+func (h *Service) isProbing(ih infohash.T) bool {
+	h.probingMu.Lock()
+	defer h.probingMu.Unlock()
+	return h.probing[ih]
+}
+
+func swarm(t *torrent.Torrent) Swarm {
+	stats := t.Stats()
+
+	return Swarm{
+		Peers:     len(t.KnownSwarm()),
+		Connected: stats.ActivePeers,
+		Seeders:   stats.ConnectedSeeders,
+		Metadata:  t.Info() != nil,
+	}
+}
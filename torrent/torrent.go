@@ -1,6 +1,7 @@
 package torrent
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,51 +9,485 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
 	"github.com/anacrolix/torrent/types/infohash"
 	"github.com/igorcafe/anyflix/config"
+	"github.com/igorcafe/anyflix/errorsx"
+	"github.com/igorcafe/anyflix/events"
+	"github.com/igorcafe/anyflix/httpx"
+	"github.com/igorcafe/anyflix/mkv"
+	"github.com/igorcafe/anyflix/probe"
 )
 
 type Service struct {
-	client *torrent.Client
+	client  *torrent.Client
+	dataDir string
+
+	// trackers holds the trackers learned for each torrent, even the ones not
+	// added yet, so they are announced to as soon as the torrent is added
+	trackersMu sync.Mutex
+	trackers   map[infohash.T][]string
+
+	// DefaultTrackers are announced to for every torrent.
+	DefaultTrackers []string
+
+	// MetadataTimeout bounds how long methods wait for a torrent's metadata,
+	// on top of the caller's context. Zero waits as long as the context.
+	MetadataTimeout time.Duration
+
+	// Readahead is how much video is fetched ahead of the playhead while
+	// streaming, turned into bytes with the bitrate of each file.
+	Readahead time.Duration
+
+	readers *readerPool
+	rates   *rateMeter
+
+	// downloads holds the files requested through DownloadFile until they
+	// are complete
+	downloadsMu sync.Mutex
+	downloads   map[fileKey]bool
+
+	// probing holds the torrents added by ProbeSwarm, which must not
+	// download data and are dropped once probed
+	probingMu sync.Mutex
+	probing   map[infohash.T]bool
+
+	bandwidth *bandwidth
+
+	// seeds tracks the torrents nothing is being downloaded from, and
+	// requested the ones downloaded through DownloadFile. past holds the
+	// seeding progress made before a torrent was last added to the client,
+	// in earlier runs or before it was dropped.
+	seedMu    sync.Mutex
+	seeds     map[infohash.T]*seedState
+	requested map[infohash.T]bool
+	past      map[infohash.T]SeedingState
+
+	// seeding is the policy of torrents without one of their own
+	seeding config.SeedingPolicy
+
+	network   config.NetworkConfig
+	nat       *natState
+	blocklist *blocklist
+
+	// SeedingPolicies, when set, returns the policies of the torrents that
+	// have one of their own, by lowercase info hash. It's called once per
+	// seeding check.
+	SeedingPolicies func() (map[string]config.SeedingPolicy, error)
+
+	// Events receives the progress and state of torrents, see
+	// PublishEvents.
+	Events *events.Bus
+
+	// OnDownloaded is called once a file requested through DownloadFile is
+	// complete on disk.
+	OnDownloaded func(path, infoHash string, fileIdx int)
+
+	// OnDeleted is called for every file deleted through Delete.
+	OnDeleted func(path, infoHash string, fileIdx int)
 }
 
-func DefaultService() (Service, error) {
-	svc := Service{}
+func DefaultService(cfg config.Config) (*Service, error) {
+	bw, err := newBandwidth(cfg.Torrent.Bandwidth)
+	if err != nil {
+		return nil, err
+	}
 
-	cfg, err := config.Load()
+	err = ValidateSeeding(cfg.Torrent.Seeding)
 	if err != nil {
-		return svc, err
+		return nil, err
 	}
 
 	config := torrent.NewDefaultClientConfig()
 	config.Seed = true
 	config.DataDir = cfg.DownloadDir
+	config.DownloadRateLimiter = bw.download
+	config.UploadRateLimiter = bw.upload
+
+	err = configureNetwork(config, cfg.Torrent.Network)
+	if err != nil {
+		return nil, err
+	}
+
+	bl, err := newBlocklist(cfg.Torrent.BlocklistPath)
+	if err != nil {
+		return nil, err
+	}
+	config.IPBlocklist = bl
 
 	err = os.MkdirAll(config.DataDir, os.ModePerm)
 	if err != nil {
-		return svc, err
+		return nil, err
 	}
 
 	client, err := torrent.NewClient(config)
 	if err != nil {
-		return svc, err
+		return nil, err
+	}
+
+	svc := &Service{
+		client:          client,
+		dataDir:         config.DataDir,
+		trackers:        map[infohash.T][]string{},
+		MetadataTimeout: time.Duration(cfg.Torrent.MetadataTimeoutSecs) * time.Second,
+		DefaultTrackers: cfg.Torrent.DefaultTrackers,
+		Readahead:       time.Duration(cfg.Torrent.ReadaheadSecs) * time.Second,
+		readers:         newReaderPool(),
+		rates:           newRateMeter(),
+		downloads:       map[fileKey]bool{},
+		probing:         map[infohash.T]bool{},
+		bandwidth:       bw,
+		seeds:           map[infohash.T]*seedState{},
+		requested:       map[infohash.T]bool{},
+		past:            map[infohash.T]SeedingState{},
+		seeding:         cfg.Torrent.Seeding,
+		network:         cfg.Torrent.Network,
+		nat:             &natState{enabled: !cfg.Torrent.Network.NoPortForwarding},
+		blocklist:       bl,
 	}
 
-	svc.client = client
+	go svc.readers.pruneEvery(readerIdleTimeout / 2)
+	go svc.rates.sampleEvery(client, time.Second)
+
+	if svc.nat.enabled {
+		svc.nat.pending = true
+		go svc.forwardPort()
+	}
+
+	if bl.path != "" && cfg.Torrent.BlocklistReloadMins > 0 {
+		go bl.reloadEvery(time.Duration(cfg.Torrent.BlocklistReloadMins) * time.Minute)
+	}
+
+	svc.applyBandwidth(time.Now())
+	go svc.applyBandwidthEvery(10 * time.Second)
+
 	return svc, nil
 }
 
-func (h Service) DownloadFile(infoHash string, fileIdx int) {
-	torrent, _ := h.client.AddTorrentInfoHash(infohash.FromHexString(infoHash))
-	<-torrent.GotInfo()
+// MetadataTimeoutError is returned when a torrent's metadata doesn't arrive
+// within Service.MetadataTimeout. It matches errorsx.Timeout.
+type MetadataTimeoutError struct {
+	InfoHash string
+	Timeout  time.Duration
+}
+
+func (e *MetadataTimeoutError) Error() string {
+	return fmt.Sprintf("no metadata for torrent %s after %s", e.InfoHash, e.Timeout)
+}
+
+func (e *MetadataTimeoutError) Is(target error) bool {
+	return target == errorsx.Timeout
+}
+
+// torrent adds the torrent, if needed, and waits for its metadata.
+func (h *Service) torrent(ctx context.Context, infoHash string) (*torrent.Torrent, error) {
+	ih, err := ParseInfoHash(infoHash)
+	if err != nil {
+		return nil, err
+	}
+
+	t, _, err := h.client.AddTorrentSpec(&torrent.TorrentSpec{
+		InfoHash: ih,
+		Trackers: h.trackerTiers(ih),
+	})
+	if err != nil {
+		return nil, err
+	}
+	h.unprobe(ih, t)
+
+	waitCtx := ctx
+	if h.MetadataTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, h.MetadataTimeout)
+		defer cancel()
+	}
+
+	select {
+	case <-t.GotInfo():
+		return t, nil
+	case <-waitCtx.Done():
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &MetadataTimeoutError{InfoHash: infoHash, Timeout: h.MetadataTimeout}
+	}
+}
+
+// AddMagnet adds the torrent of a magnet URI, along with its trackers, and
+// returns its infohash. It doesn't wait for the metadata.
+func (h *Service) AddMagnet(ctx context.Context, uri string) (string, error) {
+	m, err := ParseMagnet(uri)
+	if err != nil {
+		return "", err
+	}
+
+	h.rememberTrackers(m.InfoHash, m.Trackers)
+
+	t, _, err := h.client.AddTorrentSpec(&torrent.TorrentSpec{
+		InfoHash:    m.InfoHash,
+		DisplayName: m.DisplayName,
+		Trackers:    h.trackerTiers(m.InfoHash),
+	})
+	if err != nil {
+		return "", err
+	}
+	h.unprobe(m.InfoHash, t)
+
+	return m.InfoHash.HexString(), nil
+}
+
+// MaxTorrentFileSize is the largest .torrent file AddTorrentFile accepts.
+const MaxTorrentFileSize = 10 * 1024 * 1024
+
+// AddTorrentFile adds the torrent described by a .torrent file and returns
+// its infohash.
+func (h *Service) AddTorrentFile(ctx context.Context, r io.Reader) (string, error) {
+	mi, err := metainfo.Load(io.LimitReader(r, MaxTorrentFileSize))
+	if err != nil {
+		return "", fmt.Errorf("%w torrent file: %v", errorsx.Invalid, err)
+	}
+
+	ih := mi.HashInfoBytes()
+	h.rememberTrackers(ih, slices.Concat(mi.UpvertedAnnounceList()...))
+
+	t, err := h.client.AddTorrent(mi)
+	if err != nil {
+		return "", fmt.Errorf("%w torrent file: %v", errorsx.Invalid, err)
+	}
+	t.AddTrackers(h.trackerTiers(ih))
+	h.unprobe(ih, t)
+
+	return ih.HexString(), nil
+}
+
+// Trackers lists the trackers learned for a torrent, leaving out the
+// default ones.
+func (h *Service) Trackers(infoHash string) ([]string, error) {
+	ih, err := ParseInfoHash(infoHash)
+	if err != nil {
+		return nil, err
+	}
+
+	h.trackersMu.Lock()
+	defer h.trackersMu.Unlock()
+
+	return append([]string{}, h.trackers[ih]...), nil
+}
+
+type File struct {
+	Index int    `json:"index"`
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+}
+
+// Files lists the files of a torrent, waiting for its metadata.
+func (h *Service) Files(ctx context.Context, infoHash string) ([]File, error) {
+	t, err := h.torrent(ctx, infoHash)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []File{}
+	for i, f := range t.Files() {
+		files = append(files, File{
+			Index: i,
+			Path:  f.DisplayPath(),
+			Size:  f.Length(),
+		})
+	}
+
+	return files, nil
+}
+
+// AddTrackers records trackers for a torrent, announcing to them right away
+// if it was already added. It never adds the torrent itself.
+func (h *Service) AddTrackers(infoHash string, trackers []string) error {
+	ih, err := ParseInfoHash(infoHash)
+	if err != nil {
+		return err
+	}
+
+	if !h.rememberTrackers(ih, trackers) {
+		return nil
+	}
+
+	if t, ok := h.client.Torrent(ih); ok {
+		t.AddTrackers(h.trackerTiers(ih))
+	}
+
+	return nil
+}
+
+// rememberTrackers reports whether any of trackers is new.
+func (h *Service) rememberTrackers(ih infohash.T, trackers []string) bool {
+	h.trackersMu.Lock()
+	defer h.trackersMu.Unlock()
+
+	known := h.trackers[ih]
+	added := false
+
+	for _, tr := range trackers {
+		if tr != "" && !slices.Contains(known, tr) {
+			known = append(known, tr)
+			added = true
+		}
+	}
+
+	h.trackers[ih] = known
+	return added
+}
+
+// trackerTiers puts every tracker in its own tier, so all of them are
+// announced to instead of only the first that answers. extra trackers are
+// announced to without being remembered.
+func (h *Service) trackerTiers(ih infohash.T, extra ...string) [][]string {
+	h.trackersMu.Lock()
+	defer h.trackersMu.Unlock()
+
+	seen := map[string]bool{}
+	tiers := [][]string{}
+
+	for _, tr := range slices.Concat(h.trackers[ih], extra, h.DefaultTrackers) {
+		if tr == "" {
+			continue
+		}
+		if !seen[tr] {
+			seen[tr] = true
+			tiers = append(tiers, []string{tr})
+		}
+	}
+	return tiers
+}
+
+// file is like torrent but returns one of its files.
+func (h *Service) file(ctx context.Context, infoHash string, fileIdx int) (*torrent.File, error) {
+	// no need to wait for the metadata to reject these
+	if fileIdx < 0 {
+		return nil, fmt.Errorf("%w file index %d", errorsx.Invalid, fileIdx)
+	}
+
+	t, err := h.torrent(ctx, infoHash)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkFileIdx(t, fileIdx)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.Files()[fileIdx], nil
+}
+
+// existing returns a torrent that was already added, without adding it.
+func (h *Service) existing(infoHash string) (*torrent.Torrent, error) {
+	ih, err := ParseInfoHash(infoHash)
+	if err != nil {
+		return nil, err
+	}
+
+	t, ok := h.client.Torrent(ih)
+	if !ok {
+		return nil, fmt.Errorf("torrent %s: %w", infoHash, errorsx.NotFound)
+	}
+	return t, nil
+}
+
+// contextReader stops blocking on missing pieces once ctx is done.
+type contextReader struct {
+	torrent.Reader
+	ctx context.Context
+}
+
+func (r contextReader) Read(b []byte) (int, error) {
+	return r.ReadContext(r.ctx, b)
+}
+
+func newReader(ctx context.Context, file *torrent.File) torrent.Reader {
+	return contextReader{file.NewReader(), ctx}
+}
+
+func (h *Service) DownloadFile(ctx context.Context, infoHash string, fileIdx int) error {
+	file, err := h.file(ctx, infoHash, fileIdx)
+	if err != nil {
+		return err
+	}
 
-	file := torrent.Files()[fileIdx]
 	file.Download()
+
+	h.downloadsMu.Lock()
+	h.downloads[fileKey{file.Torrent().InfoHash(), fileIdx}] = true
+	h.downloadsMu.Unlock()
+
+	h.seedMu.Lock()
+	h.requested[file.Torrent().InfoHash()] = true
+	h.seedMu.Unlock()
+
+	go h.waitDownloaded(file, infoHash, fileIdx)
+	return nil
+}
+
+// PendingDownload is a file requested through DownloadFile that isn't
+// complete yet.
+type PendingDownload struct {
+	InfoHash string
+	FileIdx  int
+}
+
+// PendingDownloads lists the files being downloaded, so they can be resumed
+// after a restart.
+func (h *Service) PendingDownloads() []PendingDownload {
+	h.downloadsMu.Lock()
+	defer h.downloadsMu.Unlock()
+
+	pending := []PendingDownload{}
+	for key := range h.downloads {
+		pending = append(pending, PendingDownload{
+			InfoHash: key.infoHash.HexString(),
+			FileIdx:  key.fileIdx,
+		})
+	}
+	return pending
+}
+
+// Close closes the client, which stops every torrent and flushes their piece
+// completion to disk.
+func (h *Service) Close() error {
+	return errors.Join(h.client.Close()...)
+}
+
+func (h *Service) waitDownloaded(file *torrent.File, infoHash string, fileIdx int) {
+	sub := file.Torrent().SubscribePieceStateChanges()
+	defer sub.Close()
+
+	defer func() {
+		h.downloadsMu.Lock()
+		delete(h.downloads, fileKey{file.Torrent().InfoHash(), fileIdx})
+		h.downloadsMu.Unlock()
+	}()
+
+	for file.BytesCompleted() < file.Length() {
+		select {
+		case <-sub.Values:
+		case <-file.Torrent().Closed():
+			return
+		}
+	}
+
+	path := filepath.Join(h.dataDir, filepath.FromSlash(file.Path()))
+	slog.Info("finished downloading file", "path", path, "infoHash", infoHash, "fileIdx", fileIdx)
+
+	if h.OnDownloaded != nil {
+		h.OnDownloaded(path, infoHash, fileIdx)
+	}
 }
 
 type Stat struct {
@@ -67,25 +502,64 @@ type Stat struct {
 	PiecesComplete   int   `json:"piecesComplete"`
 	BytesWritten     int64 `json:"bytesWritten"`
 	BytesRead        int64 `json:"bytesRead"`
+
+	// DownloadRate and UploadRate are in bytes per second, averaged over
+	// the last few seconds.
+	DownloadRate float64 `json:"downloadRate"`
+	UploadRate   float64 `json:"uploadRate"`
+
+	// Playhead is the position of the last read of the file, and Buffered
+	// how many bytes are complete from there on.
+	Playhead int64 `json:"playhead"`
+	Buffered int64 `json:"buffered"`
+	// Bitrate is the average bitrate of the file in bytes per second, zero
+	// while unknown.
+	Bitrate int64 `json:"bitrate"`
+	// ReadyIn estimates how many seconds to wait for playback from the
+	// playhead not to stall, or -1 while unknown.
+	ReadyIn float64 `json:"readyIn"`
 }
 
-func (h Service) Drop(infoHash string) {
-	torrent, _ := h.client.AddTorrentInfoHash(infohash.FromHexString(infoHash))
-	torrent.Drop()
+// Drop removes a torrent from the client. It fails with errorsx.NotFound for
+// torrents that were never added.
+func (h *Service) Drop(ctx context.Context, infoHash string) error {
+	t, err := h.existing(infoHash)
+	if err != nil {
+		return err
+	}
+
+	h.drop(t)
+	return nil
 }
 
-func (h Service) Stat(infoHash string, fileIdx int) Stat {
-	torrent, _ := h.client.AddTorrentInfoHash(infohash.FromHexString(infoHash))
-	<-torrent.GotInfo()
+// drop removes a torrent from the client and forgets what was learned about
+// it.
+func (h *Service) drop(t *torrent.Torrent) {
+	h.readers.forget(t.InfoHash())
+	h.keepSeeding(t, time.Now())
+	t.Drop()
 
-	file := torrent.Files()[fileIdx]
-	states := file.State()
+	h.trackersMu.Lock()
+	delete(h.trackers, t.InfoHash())
+	h.trackersMu.Unlock()
 
-	stats := torrent.Stats()
+	h.bandwidth.mu.Lock()
+	delete(h.bandwidth.paused, t.InfoHash())
+	h.bandwidth.mu.Unlock()
+}
+
+// Stat reports the progress of a file of a torrent that was already added.
+// File fields stay zeroed while the metadata hasn't arrived.
+func (h *Service) Stat(ctx context.Context, infoHash string, fileIdx int) (Stat, error) {
+	t, err := h.existing(infoHash)
+	if err != nil {
+		return Stat{}, err
+	}
+
+	stats := t.Stats()
 
 	stat := Stat{
-		BytesComplete:    0,
-		BytesTotal:       file.Length(),
+		Timestamp:        time.Now().UnixMilli(),
 		TotalPeers:       stats.TotalPeers,
 		PendingPeers:     stats.PendingPeers,
 		ActivePeers:      stats.ActivePeers,
@@ -94,25 +568,48 @@ func (h Service) Stat(infoHash string, fileIdx int) Stat {
 		PiecesComplete:   stats.PiecesComplete,
 		BytesWritten:     stats.BytesWrittenData.Int64(),
 		BytesRead:        stats.BytesReadUsefulData.Int64(),
+		ReadyIn:          -1,
+	}
+
+	stat.DownloadRate, stat.UploadRate = h.rates.rates(t.InfoHash())
+
+	if t.Info() == nil {
+		return stat, nil
+	}
+
+	err = checkFileIdx(t, fileIdx)
+	if err != nil {
+		return Stat{}, err
 	}
 
-	for _, state := range states {
+	file := t.Files()[fileIdx]
+	stat.BytesTotal = file.Length()
+
+	for _, state := range file.State() {
 		if state.Completion.Complete {
 			stat.BytesComplete += state.Bytes
 		}
 	}
 
-	stat.Timestamp = time.Now().UnixMilli()
+	if sf, pos, ok := h.readers.playhead(fileKey{t.InfoHash(), fileIdx}); ok {
+		stat.Playhead = pos
+		stat.Bitrate = sf.bitrate.Load()
+	}
+	stat.Buffered = contiguous(file, stat.Playhead)
+	stat.ReadyIn = readyIn(stat.DownloadRate, stat.Bitrate, stat.Buffered, stat.BytesTotal-stat.Playhead)
 
-	return stat
+	return stat, nil
 }
 
-func (h Service) StreamFileHTTP(w http.ResponseWriter, r *http.Request, infoHash string, fileIdx int) {
-	torrent, _ := h.client.AddTorrentInfoHash(infohash.FromHexString(infoHash))
-	<-torrent.GotInfo()
-
-	// TODO: let it panic?
-	file := torrent.Files()[fileIdx]
+// StreamFileHTTP writes a chunk of the file to w. Errors are only returned
+// when nothing was written yet.
+// Readers are shared across requests for the same file, so the pieces ahead
+// of the playhead keep downloading between range requests.
+func (h *Service) StreamFileHTTP(w http.ResponseWriter, r *http.Request, infoHash string, fileIdx int) error {
+	file, err := h.file(r.Context(), infoHash, fileIdx)
+	if err != nil {
+		return err
+	}
 
 	ranges := strings.SplitN(
 		strings.TrimPrefix(r.Header.Get("Range"), "bytes="),
@@ -120,58 +617,158 @@ func (h Service) StreamFileHTTP(w http.ResponseWriter, r *http.Request, infoHash
 		2,
 	)
 
-	var start, end int64
+	const chunkSize = 10 * 1024 * 1024
+
+	var start int64
 	if len(ranges) == 2 {
 		start, _ = strconv.ParseInt(ranges[0], 10, 64)
 	}
-	const chunkSize = 10 * 1024 * 1024
-	end = start + chunkSize
-	end = min(end, file.Length())
+	start = min(max(start, 0), file.Length())
+
+	end := start + chunkSize - 1
+	if len(ranges) == 2 && ranges[1] != "" {
+		if e, err := strconv.ParseInt(ranges[1], 10, 64); err == nil && e >= start {
+			end = min(end, e)
+		}
+	}
+	end = min(end, file.Length()-1)
+
+	if start > end {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", file.Length()))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return nil
+	}
+
+	reader, err := h.streamReader(file, fileIdx, start)
+	if err != nil {
+		return err
+	}
+	defer h.readers.release(reader)
 
 	w.Header().Set("Accept-Ranges", "bytes")
-	w.Header().Set("Content-Type", "video/mp4") // TODO
+	w.Header().Set("Content-Type", httpx.VideoContentType(file.DisplayPath()))
 	w.Header().Set("Content-Length", fmt.Sprint(end-start+1))
 	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, file.Length()))
 	w.WriteHeader(http.StatusPartialContent)
 
-	reader := file.NewReader()
-	if _, err := reader.Seek(start, io.SeekStart); err != nil {
-		slog.Error("failed to seek",
-			"start", start,
-			"end", end,
-			"err", err)
-		return
-	}
-
 	slog.Debug("will start streaming chunk", "start", start, "end", end)
-	if _, err := io.CopyN(w, reader, chunkSize); err != nil {
+	if _, err := io.CopyN(w, reader.bind(r.Context()), end-start+1); err != nil {
 		slog.Error("failed to stream chunk",
 			"start", start,
 			"end", end,
 			"err", err)
-		return
 	}
+	return nil
 }
 
-func (h Service) FileHash(infoHash string, fileIdx int) (string, error) {
+func (h *Service) FileHash(ctx context.Context, infoHash string, fileIdx int) (string, error) {
 	slog.Debug("torrentSevice.getFileHash", "infoHash", infoHash, "fileIdx", fileIdx)
 
-	torrent, _ := h.client.AddTorrentInfoHash(infohash.FromHexString(infoHash))
-	<-torrent.GotInfo()
+	torrent, err := h.torrent(ctx, infoHash)
+	if err != nil {
+		return "", err
+	}
 
 	if len(torrent.Files()) == 0 {
 		return "", errors.New("invalid torrent")
 	}
 
-	if fileIdx >= len(torrent.Files()) {
-		return "", errors.New("invalid fileIdx")
+	err = checkFileIdx(torrent, fileIdx)
+	if err != nil {
+		return "", err
+	}
+
+	// the hash of the first piece of the file tells it apart, empty files
+	// at the end of the torrent have none
+	piece := torrent.Files()[fileIdx].BeginPieceIndex()
+	if piece >= torrent.NumPieces() {
+		return "", fmt.Errorf("%w file %d: empty", errorsx.Invalid, fileIdx)
 	}
 
-	hash := torrent.Piece(fileIdx).Info().Hash().HexString()
+	hash := torrent.Piece(piece).Info().Hash().HexString()
 	return hash, nil
 }
 
-func (h Service) handleGetFileHash(w http.ResponseWriter, r *http.Request) {
+// Tracks lists the audio and subtitle tracks of a Matroska file.
+func (h *Service) Tracks(ctx context.Context, infoHash string, fileIdx int) ([]mkv.Track, error) {
+	file, reader, err := h.openMatroska(ctx, infoHash, fileIdx)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	tracks := []mkv.Track{}
+	for _, track := range file.Tracks {
+		if track.Type == mkv.TrackAudio || track.Type == mkv.TrackSubtitle {
+			tracks = append(tracks, track)
+		}
+	}
+
+	return tracks, nil
+}
+
+// Subtitles extracts a text subtitle track embedded in a Matroska file.
+func (h *Service) Subtitles(ctx context.Context, infoHash string, fileIdx int, track uint64) ([]mkv.Cue, error) {
+	file, reader, err := h.openMatroska(ctx, infoHash, fileIdx)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return file.Subtitles(track)
+}
+
+// Probe reads the container headers of a file to describe its contents.
+func (h *Service) Probe(ctx context.Context, infoHash string, fileIdx int) (probe.Info, error) {
+	file, reader, err := h.headerReader(ctx, infoHash, fileIdx)
+	if err != nil {
+		return probe.Info{}, err
+	}
+	defer reader.Close()
+
+	return probe.Probe(reader, file.Length())
+}
+
+func (h *Service) openMatroska(ctx context.Context, infoHash string, fileIdx int) (*mkv.File, torrent.Reader, error) {
+	_, reader, err := h.headerReader(ctx, infoHash, fileIdx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := mkv.Open(reader)
+	if err != nil {
+		reader.Close()
+		return nil, nil, err
+	}
+
+	return file, reader, nil
+}
+
+// headerReader returns a reader suited for parsers that seek around the file
+// reading small elements.
+// The reader is bound to ctx, so reads stop waiting for pieces once the
+// request is gone.
+func (h *Service) headerReader(ctx context.Context, infoHash string, fileIdx int) (*torrent.File, torrent.Reader, error) {
+	file, err := h.file(ctx, infoHash, fileIdx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return file, newHeaderReader(ctx, file), nil
+}
+
+func newHeaderReader(ctx context.Context, file *torrent.File) torrent.Reader {
+	reader := newReader(ctx, file)
+
+	// parsers seek over everything they don't need, so only fetch the pieces
+	// that are actually read
+	reader.SetReadahead(0)
+	reader.SetResponsive()
+
+	return reader
+}
+
+func (h *Service) handleGetFileHash(w http.ResponseWriter, r *http.Request) {
 	infoHash := r.PathValue("infoHash")
 
 	fileIdx, err := strconv.Atoi(r.PathValue("fileIdx"))
@@ -180,7 +777,7 @@ func (h Service) handleGetFileHash(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hash, err := h.FileHash(infoHash, fileIdx)
+	hash, err := h.FileHash(r.Context(), infoHash, fileIdx)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
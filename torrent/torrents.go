@@ -0,0 +1,184 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/igorcafe/anyflix/config"
+	"github.com/igorcafe/anyflix/errorsx"
+)
+
+// Torrent describes a torrent in the client.
+type Torrent struct {
+	InfoHash string `json:"infoHash"`
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	// Length, BytesCompleted and Files stay zeroed while the metadata
+	// hasn't arrived.
+	Length         int64   `json:"length"`
+	BytesCompleted int64   `json:"bytesCompleted"`
+	Progress       float64 `json:"progress"`
+
+	TotalPeers       int `json:"totalPeers"`
+	ActivePeers      int `json:"activePeers"`
+	ConnectedSeeders int `json:"connectedSeeders"`
+
+	// DownloadRate and UploadRate are in bytes per second.
+	DownloadRate float64 `json:"downloadRate"`
+	UploadRate   float64 `json:"uploadRate"`
+
+	// Uploaded counts the data uploaded in earlier runs too, unlike
+	// Downloaded.
+	Uploaded   int64   `json:"uploaded"`
+	Downloaded int64   `json:"downloaded"`
+	Ratio      float64 `json:"ratio"`
+	// Streamed is set for torrents that were never downloaded through
+	// DownloadFile.
+	Streamed    bool    `json:"streamed"`
+	Seeding     bool    `json:"seeding"`
+	SeedingSecs float64 `json:"seedingSecs"`
+	// StoppedReason tells why seeding was stopped.
+	StoppedReason string               `json:"stoppedReason,omitempty"`
+	Policy        config.SeedingPolicy `json:"policy"`
+
+	Files []TorrentFile `json:"files"`
+}
+
+type TorrentFile struct {
+	Index          int    `json:"index"`
+	Path           string `json:"path"`
+	Length         int64  `json:"length"`
+	BytesCompleted int64  `json:"bytesCompleted"`
+}
+
+// Torrents lists the torrents in the client along with their seeding
+// progress, leaving out the ones only added to probe their swarm.
+func (h *Service) Torrents() []Torrent {
+	active := h.activeFiles()
+	policies := h.torrentPolicies()
+	now := time.Now()
+
+	h.seedMu.Lock()
+	defer h.seedMu.Unlock()
+
+	torrents := []Torrent{}
+
+	for _, t := range h.client.Torrents() {
+		ih := t.InfoHash()
+		if h.isProbing(ih) {
+			continue
+		}
+
+		stats := t.Stats()
+		down, up := h.rates.rates(ih)
+
+		tt := Torrent{
+			InfoHash:         ih.HexString(),
+			Name:             t.Name(),
+			State:            torrentState(t, active),
+			TotalPeers:       stats.TotalPeers,
+			ActivePeers:      stats.ActivePeers,
+			ConnectedSeeders: stats.ConnectedSeeders,
+			DownloadRate:     down,
+			UploadRate:       up,
+			Uploaded:         h.past[ih].Uploaded + stats.BytesWrittenData.Int64(),
+			Downloaded:       stats.BytesReadUsefulData.Int64(),
+			Ratio:            h.ratio(t),
+			Streamed:         !h.requested[ih],
+			Policy:           h.policy(ih, policies),
+			Files:            []TorrentFile{},
+		}
+
+		if st, ok := h.seeds[ih]; ok {
+			tt.Seeding = st.stopped == ""
+			tt.SeedingSecs = st.seeded(now).Seconds()
+			tt.StoppedReason = st.stopped
+		}
+
+		if t.Info() != nil {
+			tt.Length = t.Length()
+			tt.BytesCompleted = t.BytesCompleted()
+			if tt.Length > 0 {
+				tt.Progress = float64(tt.BytesCompleted) / float64(tt.Length)
+			}
+
+			for i, f := range t.Files() {
+				tt.Files = append(tt.Files, TorrentFile{
+					Index:          i,
+					Path:           f.Path(),
+					Length:         f.Length(),
+					BytesCompleted: f.BytesCompleted(),
+				})
+			}
+		}
+
+		torrents = append(torrents, tt)
+	}
+
+	return torrents
+}
+
+// Delete drops a torrent like Drop, and deletes its files from the download
+// dir. OnDeleted is called for each file. It fails with errorsx.NotFound for
+// torrents that aren't in the client, whose files can't be found without
+// their metadata, though their seeding progress is forgotten.
+func (h *Service) Delete(ctx context.Context, infoHash string) error {
+	t, err := h.existing(infoHash)
+	if errors.Is(err, errorsx.NotFound) {
+		// the files can't be told without the metadata, but the seeding
+		// progress has to go along with them
+		ih, _ := ParseInfoHash(infoHash)
+		h.forgetSeeding(ih)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if t.Info() == nil {
+		h.drop(t)
+		return nil
+	}
+
+	name := t.Info().BestName()
+	if !filepath.IsLocal(name) {
+		return fmt.Errorf("%w torrent name %q", errorsx.Invalid, name)
+	}
+
+	paths := []string{}
+	for _, f := range t.Files() {
+		paths = append(paths, filepath.Join(h.dataDir, filepath.FromSlash(f.Path())))
+	}
+
+	// the piece completion outlives the torrent, and would have the files
+	// taken as complete if it's added again
+	for i := range t.NumPieces() {
+		err := t.Piece(i).Storage().MarkNotComplete()
+		if err != nil {
+			slog.Warn("failed to mark piece not complete", "infoHash", infoHash, "piece", i, "err", err)
+		}
+	}
+
+	h.drop(t)
+	h.forgetSeeding(t.InfoHash())
+
+	err = os.RemoveAll(filepath.Join(h.dataDir, name))
+	if err != nil {
+		return err
+	}
+
+	slog.Info("deleted torrent data", "infoHash", infoHash, "name", name)
+
+	if h.OnDeleted != nil {
+		for i, path := range paths {
+			h.OnDeleted(path, infoHash, i)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package torrent
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/types/infohash"
+	"github.com/igorcafe/anyflix/errorsx"
+)
+
+// ParseInfoHash accepts an infohash as 40 hex characters or 32 base32
+// characters, as found in magnet links. Errors match errorsx.Invalid.
+func ParseInfoHash(s string) (infohash.T, error) {
+	var h infohash.T
+	var err error
+
+	switch len(s) {
+	case 40:
+		_, err = hex.Decode(h[:], []byte(s))
+	case 32:
+		_, err = base32.StdEncoding.Decode(h[:], []byte(strings.ToUpper(s)))
+	default:
+		err = fmt.Errorf("length %d", len(s))
+	}
+
+	if err != nil {
+		return infohash.T{}, fmt.Errorf("%w infohash %q: %v", errorsx.Invalid, s, err)
+	}
+
+	return h, nil
+}
+
+// ParseMagnet parses a magnet URI with a v1 infohash. Errors match
+// errorsx.Invalid.
+func ParseMagnet(uri string) (metainfo.Magnet, error) {
+	m, err := metainfo.ParseMagnetUri(uri)
+	if err != nil {
+		return m, fmt.Errorf("%w magnet: %v", errorsx.Invalid, err)
+	}
+	return m, nil
+}
+
+// checkFileIdx tells bad indices (400) apart from ones past the end of the
+// torrent (404).
+func checkFileIdx(t *torrent.Torrent, fileIdx int) error {
+	if fileIdx < 0 {
+		return fmt.Errorf("%w file index %d", errorsx.Invalid, fileIdx)
+	}
+	if fileIdx >= len(t.Files()) {
+		return fmt.Errorf("file %d: %w", fileIdx, errorsx.NotFound)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package torrent
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/igorcafe/anyflix/errorsx"
+)
+
+func TestParseInfoHash(t *testing.T) {
+	const want = "0123456789abcdef0123456789abcdef01234567"
+
+	tests := []struct {
+		input string
+		ok    bool
+	}{
+		{input: want, ok: true},
+		{input: "0123456789ABCDEF0123456789ABCDEF01234567", ok: true},
+		{input: "aerukz4jvpg66ajdivtytk6n54asgrlh", ok: true},
+		{input: "AERUKZ4JVPG66AJDIVTYTK6N54ASGRLH", ok: true},
+		{input: "", ok: false},
+		{input: "0123", ok: false},
+		{input: "z123456789abcdef0123456789abcdef01234567", ok: false},
+		{input: "1erukz4jvpg66asdivtytjn5ae2ejrlh", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseInfoHash(tt.input)
+			if !tt.ok {
+				if !errors.Is(err, errorsx.Invalid) {
+					t.Fatalf("expected invalid error, got %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if got.HexString() != want {
+				t.Fatalf("expected %s, got %s", want, got.HexString())
+			}
+		})
+	}
+}
+
+func TestParseMagnet(t *testing.T) {
+	m, err := ParseMagnet("magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567&dn=name&tr=udp%3A%2F%2Ftracker.example.com%3A80")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if m.DisplayName != "name" || len(m.Trackers) != 1 {
+		t.Fatalf("unexpected magnet %+v", m)
+	}
+
+	for _, uri := range []string{"", "http://example.com", "magnet:?dn=name", "magnet:?xt=urn:btih:0123"} {
+		_, err := ParseMagnet(uri)
+		if !errors.Is(err, errorsx.Invalid) {
+			t.Fatalf("expected invalid error for %q, got %v", uri, err)
+		}
+	}
+}